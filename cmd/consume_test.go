@@ -1,58 +1,588 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/beatlabs/proton/v2/internal/consumer"
+	"github.com/beatlabs/proton/v2/internal/json"
+	"github.com/beatlabs/proton/v2/internal/output"
+	"github.com/beatlabs/proton/v2/internal/protoparser"
+	another_tutorial "github.com/beatlabs/proton/v2/testdata"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestParseOffsets(t *testing.T) {
+	now := time.Date(2022, 3, 2, 10, 0, 0, 0, time.UTC)
+	beginning := consumer.Offset{Kind: consumer.OffsetBeginning}
+	end := consumer.Offset{Kind: consumer.OffsetEnd}
+	at := func(ms int64) consumer.Offset {
+		return consumer.Offset{Kind: consumer.OffsetTime, Value: ms}
+	}
+
 	tests := []struct {
-		name               string
-		given              []string
-		startTime, endTime int64
+		name            string
+		given           []string
+		start, end      consumer.Offset
+		partitionStarts map[int32]consumer.Offset
+		err             string
 	}{
 		{
-			name:      "no offsets specified",
-			given:     []string{},
-			startTime: sarama.OffsetOldest,
-			endTime:   sarama.OffsetNewest,
+			name:  "no offsets specified",
+			given: []string{},
+			start: beginning,
+			end:   end,
+		},
+		{
+			name:  "start offset specified",
+			given: []string{"s@24"},
+			start: at(24),
+			end:   end,
 		},
 		{
-			name:      "start offset specified",
-			given:     []string{"s@24"},
-			startTime: 24,
-			endTime:   sarama.OffsetNewest,
+			name:  "end offset specified",
+			given: []string{"e@42"},
+			start: beginning,
+			end:   at(42),
 		},
 		{
-			name:      "end offset specified",
-			given:     []string{"e@42"},
-			startTime: sarama.OffsetOldest,
-			endTime:   42,
+			name:  "both offsets specified",
+			given: []string{"s@24", "e@42"},
+			start: at(24),
+			end:   at(42),
 		},
 		{
-			name:      "both offsets specified",
-			given:     []string{"s@24", "e@42"},
-			startTime: 24,
-			endTime:   42,
+			name:  "multiple offsets specified",
+			given: []string{"s@24", "e@42", "s@123", "e@321"},
+			start: at(24),
+			end:   at(42),
 		},
 		{
-			name:      "multiple offsets specified",
-			given:     []string{"s@24", "e@42", "s@123", "e@321"},
-			startTime: 24,
-			endTime:   42,
+			name:  "RFC3339 times",
+			given: []string{"s@2022-03-02T09:00:00Z", "e@2022-03-02T11:00:00+01:00"},
+			start: at(now.Add(-time.Hour).UnixMilli()),
+			end:   at(now.UnixMilli()),
+		},
+		{
+			name:  "durations relative to now",
+			given: []string{"s@-1h30m", "e@+10m"},
+			start: at(now.Add(-90 * time.Minute).UnixMilli()),
+			end:   at(now.Add(10 * time.Minute).UnixMilli()),
+		},
+		{
+			name:  "end",
+			given: []string{"end"},
+			start: end,
+			end:   end,
+		},
+		{
+			name:  "beginning",
+			given: []string{"beginning", "e@42"},
+			start: beginning,
+			end:   at(42),
+		},
+		{
+			name:  "absolute offset",
+			given: []string{"1500"},
+			start: consumer.Offset{Kind: consumer.OffsetAbsolute, Value: 1500},
+			end:   end,
+		},
+		{
+			name:  "relative offset",
+			given: []string{"-100"},
+			start: consumer.Offset{Kind: consumer.OffsetRelative, Value: 100},
+			end:   end,
+		},
+		{
+			name:  "per-partition offsets",
+			given: []string{"3:1500", "0:beginning", "1:-10", "s@24", "3:end"},
+			start: at(24),
+			end:   end,
+			partitionStarts: map[int32]consumer.Offset{
+				0: beginning,
+				1: {Kind: consumer.OffsetRelative, Value: 10},
+				3: {Kind: consumer.OffsetAbsolute, Value: 1500},
+			},
+		},
+		{
+			name:  "unknown offset",
+			given: []string{"stored"},
+			err:   `invalid offset "stored": expected beginning, end, an offset or a negative count of messages before the end`,
+		},
+		{
+			name:  "invalid time",
+			given: []string{"s@yesterday"},
+			err:   `invalid offset "s@yesterday": expected a timestamp in milliseconds, an RFC3339 time or a duration relative to now like -1h`,
+		},
+		{
+			name:  "negative timestamp",
+			given: []string{"e@-42"},
+			err:   `invalid offset "e@-42": expected a timestamp in milliseconds, an RFC3339 time or a duration relative to now like -1h`,
+		},
+		{
+			name:  "invalid partition",
+			given: []string{"x:1500"},
+			err:   `invalid offset "x:1500": invalid partition "x"`,
+		},
+		{
+			name:  "invalid partition offset",
+			given: []string{"3:s@24"},
+			err:   `invalid offset "3:s@24": expected beginning, end, an offset or a negative count of messages before the end`,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			// given
 			// when
-			r1, r2 := parseOffsets(test.given)
+			start, end, partitionStarts, err := parseOffsets(test.given, now)
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.start, start)
+			assert.Equal(t, test.end, end)
+			if test.partitionStarts == nil {
+				test.partitionStarts = map[int32]consumer.Offset{}
+			}
+			assert.Equal(t, test.partitionStarts, partitionStarts)
+		})
+	}
+}
+
+func TestParseHeaderGreps(t *testing.T) {
+	tests := []struct {
+		name     string
+		given    []string
+		expected []consumer.HeaderGrep
+		err      string
+	}{
+		{
+			name: "no header filters specified",
+		},
+		{
+			name:  "patterns can contain the separator",
+			given: []string{`ce-type=^order\.`, "query=a=b", "empty="},
+			expected: []consumer.HeaderGrep{
+				{Name: "ce-type", Pattern: `^order\.`},
+				{Name: "query", Pattern: "a=b"},
+				{Name: "empty", Pattern: ""},
+			},
+		},
+		{
+			name:  "missing name",
+			given: []string{"=abc"},
+			err:   `invalid header filter "=abc", expected <name>=<regexp>`,
+		},
+		{
+			name:  "missing separator",
+			given: []string{"trace-id"},
+			err:   `invalid header filter "trace-id", expected <name>=<regexp>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			res, err := parseHeaderGreps(test.given)
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	tests := []struct {
+		name     string
+		given    []string
+		expected []topicType
+		err      string
+	}{
+		{
+			name: "no types specified",
+		},
+		{
+			name:  "topics and patterns",
+			given: []string{"orders=shop.v1.Order", "^pay=ments$=pay.v1.Payment"},
+			expected: []topicType{
+				{topic: "orders", messageType: "shop.v1.Order"},
+				{topic: "^pay=ments$", messageType: "pay.v1.Payment"},
+			},
+		},
+		{
+			name:  "missing topic",
+			given: []string{"=shop.v1.Order"},
+			err:   `invalid message type mapping "=shop.v1.Order", expected <topic>=<message type>`,
+		},
+		{
+			name:  "missing message type",
+			given: []string{"orders="},
+			err:   `invalid message type mapping "orders=", expected <topic>=<message type>`,
+		},
+		{
+			name:  "missing separator",
+			given: []string{"orders"},
+			err:   `invalid message type mapping "orders", expected <topic>=<message type>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			res, err := parseTypes(test.given)
 
 			// then
-			assert.Equal(t, test.startTime, r1)
-			assert.Equal(t, test.endTime, r2)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
 		})
 	}
 }
+
+func TestNewTopicDecoder(t *testing.T) {
+	parser, fileName, err := protoparser.NewFile("../testdata/addressbook.proto")
+	assert.NoError(t, err)
+
+	decoder, err := newTopicDecoder(json.Converter{Parser: parser, Filename: fileName}, "tutorial.Person", []topicType{
+		{topic: "books", messageType: "tutorial.AddressBook"},
+		{topic: "^phones", messageType: "tutorial.Person.PhoneNumber"},
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		topic    string
+		msg      proto.Message
+		expected string
+	}{
+		{
+			topic:    "people",
+			msg:      &another_tutorial.Person{Name: "ABC", Id: 1},
+			expected: `{"name":"ABC","id":1}`,
+		},
+		{
+			topic:    "books",
+			msg:      &another_tutorial.AddressBook{People: []*another_tutorial.Person{{Name: "ABC"}}},
+			expected: `{"people":[{"name":"ABC"}]}`,
+		},
+		{
+			topic:    "phones.1",
+			msg:      &another_tutorial.Person_PhoneNumber{Number: "123"},
+			expected: `{"number":"123"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.topic, func(t *testing.T) {
+			value, err := proto.Marshal(test.msg)
+			assert.NoError(t, err)
+
+			res, err := decoder.DecodeMessage(&sarama.ConsumerMessage{Topic: test.topic, Value: value})
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, res)
+		})
+	}
+
+	_, err = newTopicDecoder(json.Converter{Parser: parser, Filename: fileName}, "", []topicType{{topic: "books", messageType: "tutorial.Book"}})
+	assert.EqualError(t, err, "can't find Book in tutorial package")
+}
+
+func TestParseHeaderTypes(t *testing.T) {
+	res, err := parseHeaderTypes([]string{"order.created=shop.v1.Order", "a=b=pay.v1.Payment"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"order.created": "shop.v1.Order", "a=b": "pay.v1.Payment"}, res)
+
+	_, err = parseHeaderTypes([]string{"order.created"})
+	assert.EqualError(t, err, `invalid header type mapping "order.created", expected <header value>=<message type>`)
+}
+
+func TestNewHeaderDecoder(t *testing.T) {
+	parser, fileName, err := protoparser.NewFile("../testdata/addressbook.proto")
+	assert.NoError(t, err)
+
+	fallback, err := newTopicDecoder(json.Converter{Parser: parser, Filename: fileName}, "tutorial.Person", nil)
+	assert.NoError(t, err)
+
+	decoder, err := newHeaderDecoder(json.Converter{Parser: parser, Filename: fileName}, "proto-type", map[string]string{"book": "tutorial.AddressBook"}, fallback)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		header   string
+		msg      proto.Message
+		expected string
+		err      string
+	}{
+		{
+			name:     "no header",
+			msg:      &another_tutorial.Person{Name: "ABC", Id: 1},
+			expected: `{"name":"ABC","id":1}`,
+		},
+		{
+			name:     "mapped header value",
+			header:   "book",
+			msg:      &another_tutorial.AddressBook{People: []*another_tutorial.Person{{Name: "ABC"}}},
+			expected: `{"people":[{"name":"ABC"}]}`,
+		},
+		{
+			name:     "nested message type",
+			header:   "tutorial.Person.PhoneNumber",
+			msg:      &another_tutorial.Person_PhoneNumber{Number: "123"},
+			expected: `{"number":"123"}`,
+		},
+		{
+			name:   "unknown message type",
+			header: "tutorial.Book",
+			msg:    &another_tutorial.Person{Name: "ABC"},
+			err:    `unknown message type "tutorial.Book" in header proto-type of people [0] at offset 7`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := proto.Marshal(test.msg)
+			assert.NoError(t, err)
+
+			message := &sarama.ConsumerMessage{Topic: "people", Offset: 7, Value: value}
+			if test.header != "" {
+				message.Headers = []*sarama.RecordHeader{{Key: []byte("proto-type"), Value: []byte(test.header)}}
+			}
+
+			res, err := decoder.DecodeMessage(message)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, res)
+		})
+	}
+
+	_, err = newHeaderDecoder(json.Converter{Parser: parser, Filename: fileName}, "proto-type", map[string]string{"book": "tutorial.Book"}, fallback)
+	assert.EqualError(t, err, "unknown message type tutorial.Book mapped to header value book")
+}
+
+func TestValueFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		filters  []string
+		template string
+		expected json.Format
+		err      string
+	}{
+		{
+			name:     "json with filters",
+			format:   "json",
+			filters:  []string{`.name == "ABC"`},
+			expected: json.FormatJSON,
+		},
+		{
+			name:     "indented json with a template",
+			format:   "json-indent",
+			template: "{{.Value.name}}",
+			expected: json.FormatJSONIndent,
+		},
+		{
+			name:     "yaml",
+			format:   "yaml",
+			expected: json.FormatYAML,
+		},
+		{
+			name:    "textproto with filters",
+			format:  "textproto",
+			filters: []string{`.name == "ABC"`},
+			err:     "filters and templates require json messages, they can't be combined with the textproto format",
+		},
+		{
+			name:     "yaml with a template",
+			format:   "yaml",
+			template: "{{.Value.name}}",
+			err:      "filters and templates require json messages, they can't be combined with the yaml format",
+		},
+		{
+			name:   "unknown format",
+			format: "xml",
+			err:    `unknown format "xml", expected one of json, json-indent, yaml, textproto`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			defer func(cfg ConsumeCfg) { *consumeCfg = cfg }(*consumeCfg)
+			consumeCfg.valueFormat, consumeCfg.consumerCfg.Filters, consumeCfg.template = test.format, test.filters, test.template
+
+			// when
+			res, err := valueFormat()
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestNewKeyDecoder(t *testing.T) {
+	key, err := proto.Marshal(&another_tutorial.Person_PhoneNumber{Number: "123"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		model    string
+		keyModel string
+		keyType  string
+		expected string
+		err      string
+	}{
+		{
+			name:  "keys aren't decoded",
+			model: "../testdata/addressbook.proto",
+		},
+		{
+			name:     "key type from the value proto file",
+			model:    "../testdata/addressbook.proto",
+			keyType:  "tutorial.Person.PhoneNumber",
+			expected: `{"number":"123"}`,
+		},
+		{
+			name:     "key proto file",
+			keyModel: "../testdata/addressbook.proto",
+			keyType:  "tutorial.Person.PhoneNumber",
+			expected: `{"number":"123"}`,
+		},
+		{
+			name:    "no proto file",
+			keyType: "tutorial.Person.PhoneNumber",
+			err:     "decoding keys requires a proto file, use the `--key-proto <path>` option",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			defer func(cfg ConsumeCfg) { *consumeCfg = cfg }(*consumeCfg)
+			consumeCfg.model, consumeCfg.keyModel, consumeCfg.keyType = test.model, test.keyModel, test.keyType
+
+			// when
+			decoder, err := newKeyDecoder(context.Background())
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			if test.expected == "" {
+				assert.Nil(t, decoder)
+				return
+			}
+
+			res, err := decoder.Decode(key)
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, res)
+		})
+	}
+}
+
+func TestNewPrinter(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		format   string
+		template string
+		expected output.Printer
+		err      string
+	}{
+		{
+			name:     "format",
+			output:   "text",
+			expected: &output.FormattedPrinter{},
+		},
+		{
+			name:     "template",
+			output:   "text",
+			template: "{{.Value}}",
+			expected: &output.TemplatePrinter{},
+		},
+		{
+			name:     "json",
+			output:   "json",
+			expected: &output.JSONPrinter{},
+		},
+		{
+			name:   "json with a format",
+			output: "json",
+			format: "%s",
+			err:    "the json output can't be combined with the format or the template",
+		},
+		{
+			name:     "json with a template",
+			output:   "json",
+			template: "{{.Value}}",
+			err:      "the json output can't be combined with the format or the template",
+		},
+		{
+			name:   "unknown output",
+			output: "xml",
+			err:    `unknown output "xml", expected text or json`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			defer func(cfg ConsumeCfg) { *consumeCfg = cfg }(*consumeCfg)
+			consumeCfg.output, consumeCfg.template = test.output, test.template
+
+			cmd := &cobra.Command{}
+			cmd.Flags().StringVarP(&consumeCfg.format, "format", "f", "%Tf: %s", "")
+			if test.format != "" {
+				require.NoError(t, cmd.Flags().Set("format", test.format))
+			}
+
+			// when
+			printer, err := newPrinter(cmd)
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, test.expected, printer)
+		})
+	}
+}
+
+func TestReadSASLPassword(t *testing.T) {
+	t.Setenv(saslPasswordEnv, "env-pass")
+
+	res, err := readSASLPassword("")
+	assert.NoError(t, err)
+	assert.Equal(t, "env-pass", res)
+
+	path := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(path, []byte("file-pass\n"), 0600))
+
+	res, err = readSASLPassword(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "file-pass", res)
+
+	_, err = readSASLPassword(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
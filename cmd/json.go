@@ -3,10 +3,12 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/beatlabs/proton/v2/internal/json"
 	"github.com/beatlabs/proton/v2/internal/protoparser"
@@ -37,27 +39,91 @@ var jsonCmd = &cobra.Command{
 			}
 		}
 
+		format, err := json.ParseFormat(outputFormat)
+		if err != nil {
+			return err
+		}
+		if indent {
+			if format != json.FormatJSON {
+				return errors.New("`--indent` only applies to the json output, use `--output json-indent` instead")
+			}
+			format = json.FormatJSONIndent
+		}
+
+		framing, err := json.ParseFraming(framingName)
+		if err != nil {
+			return err
+		}
+
+		encoding, err := json.ParseEncoding(encodingName)
+		if err != nil {
+			return err
+		}
+		if len(b64Messages) > 0 {
+			if embeddedPath != "" || kcat {
+				return errors.New("`--b64` messages can't be combined with `--embedded-path` or `--kcat`, pipe the json lines instead")
+			}
+			// stdin is ignored rather than rejected, as it isn't a terminal in scripts, e.g. in while read loops
+			if len(args) > 0 {
+				return errors.New("`--b64` messages are converted instead of the input, they can't be combined with an input file")
+			}
+			if !cmd.Flags().Changed("encoding") {
+				encoding = json.EncodingBase64
+			} else if encoding != json.EncodingBase64 && encoding != json.EncodingBase64URL {
+				return fmt.Errorf("`--b64` messages are base64 encoded, they can't be combined with the %s encoding", encoding)
+			}
+		}
+
+		var keyDecoder *json.Decoder
+		if keyType != "" {
+			if !kcat {
+				return errors.New("only the keys of kcat envelopes can be decoded, use the `--kcat` option")
+			}
+			keyPkg, keyName := splitMessageType(keyType)
+			keyDecoder, err = json.Converter{
+				Parser:      protoParser,
+				Filename:    fileName,
+				Package:     keyPkg,
+				MessageType: keyName,
+				Options:     marshalOptions,
+			}.NewDecoder()
+			if err != nil {
+				return err
+			}
+		}
+
 		c := json.Converter{
 			Parser:             protoParser,
 			Filename:           fileName,
 			Package:            pkg,
 			MessageType:        messageType,
-			Indent:             indent,
 			EndOfMessageMarker: endOfMessageMarker,
+			Format:             format,
+			Options:            marshalOptions,
+			Framing:            framing,
+			Encoding:           encoding,
+			EmbeddedPath:       embeddedPath,
+			KcatEnvelope:       kcat,
+			KeyDecoder:         keyDecoder,
 		}
 
-		r := os.Stdin
-		if !isInputFromPipe() {
+		var r io.Reader = os.Stdin
+		switch {
+		case len(b64Messages) > 0:
+			// a message per line, so that errors tell the failing argument
+			r = strings.NewReader(strings.Join(b64Messages, "\n"))
+		case !isInputFromPipe():
 			if len(args) != 1 {
 				return errors.New("input file path is empty")
 			}
 
-			r, err = getFile(args[0])
+			f, err := getFile(args[0])
 			if err != nil {
 				return err
 			}
 
-			defer r.Close()
+			defer f.Close()
+			r = f
 		}
 
 		resultCh, errorCh := c.ConvertStream(r)
@@ -70,6 +136,10 @@ var jsonCmd = &cobra.Command{
 					done = true
 					break
 				}
+				if format == json.FormatYAML {
+					// separates the messages as yaml documents
+					_, _ = fmt.Fprintln(os.Stdout, "---")
+				}
 				_, _ = fmt.Fprintln(os.Stdout, string(m))
 			case e, ok := <-errorCh:
 				if !ok {
@@ -92,6 +162,14 @@ var file string
 var pkg string
 var messageType string
 var endOfMessageMarker string
+var outputFormat string
+var marshalOptions json.MarshalOptions
+var framingName string
+var encodingName string
+var b64Messages []string
+var embeddedPath string
+var kcat bool
+var keyType string
 
 func init() {
 	rootCmd.AddCommand(jsonCmd)
@@ -108,6 +186,36 @@ func init() {
 		"\nDefaults to the first message type in the Proton file if not specified")
 	jsonCmd.Flags().StringVarP(&endOfMessageMarker, "end-of-message-marker", "m", "",
 		"Marker for end of message used when piping data")
+	jsonCmd.Flags().StringVar(&framingName, "framing", string(json.FramingMarker), "How messages are delimited when piping data:"+
+		"\n\tmarker     followed by the end of message marker, or the whole input without a marker"+
+		"\n\tvarint     prefixed with their length as a varint, like Java's writeDelimitedTo"+
+		"\n\tuint32be   prefixed with their length as a 4-byte big-endian integer"+
+		"\n\tuint32le   prefixed with their length as a 4-byte little-endian integer"+
+		"\n\tsingle     the whole input is a single message")
+	jsonCmd.Flags().StringVar(&encodingName, "encoding", string(json.EncodingRaw), "How messages are encoded:"+
+		"\n\traw         binary messages, delimited by the framing"+
+		"\n\tbase64      a base64 encoded message per line"+
+		"\n\tbase64url   a URL-safe base64 encoded message per line"+
+		"\n\thex         a hex encoded message per line")
+	jsonCmd.Flags().StringArrayVar(&b64Messages, "b64", nil, "A base64 encoded message to convert instead of the input, which is then ignored. Can be repeated")
+	jsonCmd.Flags().StringVar(&embeddedPath, "embedded-path", "", "Path of a message embedded in json lines, e.g. .payload"+
+		"\nEach line of the input is a json object, e.g. a log line, and the message at the path is replaced with the converted one"+
+		"\nEmbedded messages are base64 encoded, unless another encoding is given with --encoding")
+	jsonCmd.Flags().BoolVar(&kcat, "kcat", false, "Whether the input is the json envelopes of kcat -J"+
+		"\nThe envelopes are printed as they are, with the payload replaced with the converted message")
+	jsonCmd.Flags().StringVar(&keyType, "key-type", "", "Fully qualified message type of the keys of kcat envelopes, e.g. tutorial.Person.PhoneNumber"+
+		"\nKeys are left as they are if not specified")
+	jsonCmd.Flags().StringVar(&outputFormat, "output", string(json.FormatJSON), "Output format: json, json-indent, yaml or textproto")
+	addMarshalOptionFlags(jsonCmd, &marshalOptions)
+}
+
+// addMarshalOptionFlags adds the flags of the options of converting messages to json, shared by the commands that decode messages.
+func addMarshalOptionFlags(cmd *cobra.Command, options *json.MarshalOptions) {
+	cmd.Flags().BoolVar(&options.EmitDefaults, "emit-defaults", false, "Whether to print the fields with zero values, which are omitted otherwise")
+	cmd.Flags().BoolVar(&options.OrigName, "orig-name", false, "Whether to print the field names of the proto file instead of the camelCased json names")
+	cmd.Flags().BoolVar(&options.EnumsAsInts, "enums-as-ints", false, "Whether to print enums as their numbers instead of their names")
+	cmd.Flags().BoolVar(&options.Int64AsNumbers, "int64-as-numbers", false,
+		"Whether to print 64-bit integers as numbers instead of strings\nNumbers over 2^53 lose precision in many json parsers")
 }
 
 func isInputFromPipe() bool {
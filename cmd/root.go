@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/mitchellh/go-homedir"
@@ -12,6 +13,9 @@ import (
 	"github.com/spf13/viper"
 )
 
+// envPrefix is the prefix of the environment variables of the config, e.g. PROTON_TYPES for types.
+const envPrefix = "PROTON"
+
 var cfgFile string
 var version string
 
@@ -70,10 +74,13 @@ func initConfig() {
 		viper.SetConfigName(".proton")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, prefixed so that e.g. TYPES doesn't change the message types
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stdout, "Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitConfig_Env(t *testing.T) {
+	// given
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TYPES", "orders=shop.v1.Order")
+	t.Setenv("PROTON_HEADER_TYPES", "order.created=shop.v1.Order")
+
+	// when
+	initConfig()
+
+	// then
+	assert.Empty(t, viper.GetStringSlice("types"))
+	assert.Equal(t, []string{"order.created=shop.v1.Order"}, viper.GetStringSlice("header-types"))
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/beatlabs/proton/v2/internal/consumer"
+	"github.com/beatlabs/proton/v2/internal/segment"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// segmentCmd represents the segment command
+var segmentCmd = &cobra.Command{
+	Use:   "segment <file.log>...",
+	Short: "decode the messages of Kafka log segment files",
+	Long: `Decode the messages of Kafka log segment files offline, e.g. from a broker's data directory or a backup.
+Segments are read as record batches v2, compressed or not, skipping control records like transaction markers.
+The messages are decoded, filtered and printed like the consumed ones.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  RunSegment,
+}
+
+// segmentTopic and segmentPartition override the topic and the partition of the segments,
+// which default to the ones of their directory.
+var (
+	segmentTopic     string
+	segmentPartition int32
+)
+
+func init() {
+	rootCmd.AddCommand(segmentCmd)
+
+	addMessageFlags(segmentCmd)
+
+	segmentCmd.Flags().StringVarP(&segmentTopic, "topic", "t", "", `
+The topic of the messages, used to pick their message type with --types and printed with %t.
+Defaults to the topic of the segment's directory, e.g. orders for /var/lib/kafka/data/orders-3/00000000000000000000.log`)
+	segmentCmd.Flags().Int32VarP(&segmentPartition, "partition", "p", -1,
+		"The partition of the messages, printed with %p\nDefaults to the partition of the segment's directory")
+}
+
+// RunSegment decodes and prints the messages of all the given segments, in order.
+func RunSegment(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	if consumeCfg.consumerCfg.MaxMessages < 0 {
+		log.Fatal("the message count can't be negative")
+	}
+
+	// the config is bound to the options of consume when it's set up, the ones of this command are used instead
+	for _, name := range []string{"types", "header-types"} {
+		if err := viper.BindPFlag(name, cmd.Flags().Lookup(name)); err != nil {
+			log.Fatal(fmt.Errorf("failed binding the `--%s` option to the config: %w", name, err))
+		}
+	}
+
+	var err error
+	consumeCfg.consumerCfg.HeaderGreps, err = parseHeaderGreps(consumeCfg.headerGreps)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	decoder, err := newDecoder(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyDecoder, err := newKeyDecoder(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	printer, err := newPrinter(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	processor, err := consumer.NewProcessor(ctx, consumeCfg.consumerCfg, decoder, keyDecoder, printer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, path := range args {
+		done, err := processSegment(processor, path, segmentTopic, segmentPartition, printer.PrintErr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// processSegment processes all the messages of a segment, and tells whether the processor is done, e.g. once the
+// message count is reached. Errors about single batches are given to printErr, and reading goes on with the next batch.
+// The topic and the partition are the ones of the segment's directory, unless they're given.
+func processSegment(processor *consumer.Kafka, path, topic string, partition int32, printErr func(error)) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	dirTopic, dirPartition, _ := segment.TopicPartition(path)
+	if topic == "" {
+		topic = dirTopic
+	}
+	if partition < 0 {
+		partition = dirPartition
+	}
+
+	r := segment.NewReader(f, topic, partition)
+	for {
+		message, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			printErr(fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+
+		if !processor.Process(message) {
+			return true, nil
+		}
+	}
+}
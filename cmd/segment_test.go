@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/beatlabs/proton/v2/internal/consumer"
+	"github.com/beatlabs/proton/v2/internal/json"
+	"github.com/beatlabs/proton/v2/internal/output"
+	"github.com/beatlabs/proton/v2/internal/protoparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessSegment(t *testing.T) {
+	const path = "../testdata/orders-3/00000000000000000000.log"
+
+	parser, fileName, err := protoparser.NewFile("../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	decoder, err := newTopicDecoder(json.Converter{Parser: parser, Filename: fileName}, "tutorial.Person", nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		cfg       consumer.Cfg
+		topic     string
+		partition int32
+		expected  string
+		done      bool
+	}{
+		{
+			name:      "topic and partition of the directory",
+			cfg:       consumer.Cfg{KeyGrep: ".*"},
+			partition: -1,
+			expected: `orders [3] 0 1 ce-type=person.created {"name":"Alice","id":1,"email":"alice@example.com"}
+orders [3] 1 2 ce-type=person.created {"name":"Bob","id":2,"email":"bob@example.com"}
+orders [3] 3 1 ce-type=person.updated {"name":"Alice","id":1,"email":"alice@example.org"}
+`,
+		},
+		{
+			name:      "given topic and partition",
+			cfg:       consumer.Cfg{KeyGrep: "^2$"},
+			topic:     "people",
+			partition: 0,
+			expected: `people [0] 1 2 ce-type=person.created {"name":"Bob","id":2,"email":"bob@example.com"}
+`,
+		},
+		{
+			name:      "message count",
+			cfg:       consumer.Cfg{KeyGrep: ".*", Filters: []string{`.id == 1`}, MaxMessages: 1},
+			partition: -1,
+			expected: `orders [3] 0 1 ce-type=person.created {"name":"Alice","id":1,"email":"alice@example.com"}
+`,
+			done: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var out, errOut bytes.Buffer
+			printer := output.NewFormatterPrinter(`%t [%p] %o %k %h %s`, &out, &errOut)
+			processor, err := consumer.NewProcessor(context.Background(), test.cfg, decoder, nil, printer)
+			require.NoError(t, err)
+
+			var errs []error
+
+			// when
+			done, err := processSegment(processor, path, test.topic, test.partition, func(err error) { errs = append(errs, err) })
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, test.done, done)
+			assert.Equal(t, test.expected, out.String())
+			assert.Empty(t, errs)
+			assert.Empty(t, errOut.String())
+		})
+	}
+}
+
+func TestProcessSegment_MissingFile(t *testing.T) {
+	processor, err := consumer.NewProcessor(context.Background(), consumer.Cfg{}, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = processSegment(processor, "../testdata/orders-3/missing.log", "", -1, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+package consumer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	defaultPort     = "9092"
+	brokerURIScheme = "kafka://"
+)
+
+// newConfig returns the bootstrap brokers and the sarama config to connect to them, as given in the consumer's configuration.
+// The broker URL is either a comma-separated list of brokers, e.g. host1:9092,host2,
+// or a URI with the brokers and the connection parameters, e.g. kafka://host1,host2/?tls=true&sasl=scram-sha-512.
+func newConfig(cfg Cfg) ([]string, *sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "proton-consumer"
+	config.Consumer.Return.Errors = true
+	config.Version = sarama.V0_11_0_0
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+
+	brokers, params, err := parseBrokers(cfg.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, values := range params {
+		value := values[len(values)-1]
+		switch name {
+		case "tls":
+			config.Net.TLS.Enable, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid tls parameter %q in the broker URI", value)
+			}
+		case "sasl":
+			config.Net.SASL.Mechanism, err = saslMechanism(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			config.Net.SASL.Enable = true
+		default:
+			return nil, nil, fmt.Errorf("unknown parameter %q in the broker URI", name)
+		}
+	}
+
+	if cfg.Group != "" {
+		// Partitions without a committed offset are moved to the configured start offset when they're claimed.
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+		config.Consumer.Offsets.AutoCommit.Enable = cfg.Commit
+	}
+
+	if err := applyTLS(cfg.TLS, config); err != nil {
+		return nil, nil, err
+	}
+
+	if err := applySASL(cfg.SASL, config); err != nil {
+		return nil, nil, err
+	}
+
+	return brokers, config, nil
+}
+
+// parseBrokers parses a comma-separated list of brokers, or a broker URI, to the list of bootstrap brokers and the URI parameters.
+// Brokers without a port get the default Kafka port.
+func parseBrokers(uri string) ([]string, url.Values, error) {
+	hosts, params := uri, url.Values{}
+	if strings.HasPrefix(uri, brokerURIScheme) {
+		hosts = strings.TrimPrefix(uri, brokerURIScheme)
+		if i := strings.IndexAny(hosts, "/?"); i >= 0 {
+			query := strings.TrimPrefix(hosts[i:], "/")
+			hosts = hosts[:i]
+
+			if query != "" && !strings.HasPrefix(query, "?") {
+				return nil, nil, fmt.Errorf("unexpected path %q in the broker URI", query)
+			}
+
+			var err error
+			params, err = url.ParseQuery(strings.TrimPrefix(query, "?"))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid broker URI parameters: %w", err)
+			}
+		}
+	}
+
+	var brokers []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+		}
+
+		brokers = append(brokers, host)
+	}
+
+	if len(brokers) == 0 {
+		return nil, nil, fmt.Errorf("no brokers found in %q", uri)
+	}
+
+	return brokers, params, nil
+}
@@ -0,0 +1,108 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		sasl    SASLCfg
+		brokers []string
+		assert  func(*testing.T, *sarama.Config)
+		err     string
+	}{
+		{
+			name:    "single broker without port",
+			url:     "localhost",
+			brokers: []string{"localhost:9092"},
+		},
+		{
+			name:    "single broker with port",
+			url:     "localhost:9093",
+			brokers: []string{"localhost:9093"},
+		},
+		{
+			name:    "comma-separated brokers",
+			url:     "host1:9093, host2,[::1],[::1]:9094",
+			brokers: []string{"host1:9093", "host2:9092", "[::1]:9092", "[::1]:9094"},
+		},
+		{
+			name:    "URI without parameters",
+			url:     "kafka://host1,host2:9093",
+			brokers: []string{"host1:9092", "host2:9093"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.False(t, config.Net.TLS.Enable)
+				assert.False(t, config.Net.SASL.Enable)
+			},
+		},
+		{
+			name:    "URI with parameters",
+			url:     "kafka://host1,host2/?tls=true&sasl=SCRAM-SHA-512",
+			sasl:    SASLCfg{User: "user", Password: "pass"},
+			brokers: []string{"host1:9092", "host2:9092"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.True(t, config.Net.TLS.Enable)
+				assert.True(t, config.Net.SASL.Enable)
+				assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+			},
+		},
+		{
+			name:    "URI with parameters without a slash",
+			url:     "kafka://host1?sasl=plain",
+			sasl:    SASLCfg{User: "user", Password: "pass"},
+			brokers: []string{"host1:9092"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.False(t, config.Net.TLS.Enable)
+				assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), config.Net.SASL.Mechanism)
+			},
+		},
+		{
+			name: "no brokers",
+			url:  "kafka://?tls=true",
+			err:  `no brokers found in "kafka://?tls=true"`,
+		},
+		{
+			name: "URI with path",
+			url:  "kafka://host1/path",
+			err:  `unexpected path "path" in the broker URI`,
+		},
+		{
+			name: "unknown parameter",
+			url:  "kafka://host1/?compression=gzip",
+			err:  `unknown parameter "compression" in the broker URI`,
+		},
+		{
+			name: "invalid tls parameter",
+			url:  "kafka://host1/?tls=maybe",
+			err:  `invalid tls parameter "maybe" in the broker URI`,
+		},
+		{
+			name: "unknown SASL mechanism",
+			url:  "kafka://host1/?sasl=gssapi",
+			err:  `unknown SASL mechanism "gssapi", expected one of plain, scram-sha-256, scram-sha-512, oauthbearer`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			brokers, config, err := newConfig(Cfg{URL: test.url, SASL: test.sasl})
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.brokers, brokers)
+			assert.Equal(t, "proton-consumer", config.ClientID)
+			if test.assert != nil {
+				test.assert(t, config)
+			}
+		})
+	}
+}
@@ -2,201 +2,603 @@ package consumer
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net/url"
+	"os"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/beatlabs/proton/v2/internal/filter"
 	"github.com/beatlabs/proton/v2/internal/output"
 	"github.com/beatlabs/proton/v2/internal/protoparser"
 )
 
-const defaultPort = "9092"
+const (
+	// topicPatternPrefix marks a topic as a regular expression matched against the cluster's topics, like librdkafka does.
+	topicPatternPrefix = "^"
+	// internalTopicPrefix is the prefix of Kafka's internal topics, which are never matched by topic patterns.
+	internalTopicPrefix = "__"
+)
 
 // Cfg is the configuration of this consumer.
+// URL is either a comma-separated list of bootstrap brokers or a kafka:// URI, see newConfig.
+// Topics starting with "^" are regular expressions which are expanded against the cluster's topics.
+// Start and End apply to all partitions, unless a partition has its own start in PartitionStarts.
+// If ExitAtEnd is set, the end of each partition is at most its high watermark when the consumer starts.
+// Only the messages whose key matches KeyGrep, and which have a matching header for each of HeaderGreps, are printed.
+// Filters are jq-style expressions over the decoded messages which must all match, see filter.Expr.
+// InvertFilters prints the messages the filters don't match instead, and FilterIgnoreCase compares strings case-insensitively.
+// If MaxMessages is set, the consumer stops after printing that many matching messages.
+// If IdleTimeout is set, the consumer stops once no message is received for that long.
+// If Group is set, the consumer joins the consumer group, and commits the consumed offsets if Commit is set.
 type Cfg struct {
-	URL        string
-	Topic      string
-	Start, End int64
-	Verbose    bool
-	KeyGrep    string
+	URL              string
+	Topics           []string
+	Start, End       Offset
+	PartitionStarts  map[int32]Offset
+	Verbose          bool
+	KeyGrep          string
+	HeaderGreps      []HeaderGrep
+	Filters          []string
+	InvertFilters    bool
+	FilterIgnoreCase bool
+	SASL             SASLCfg
+	TLS              TLSCfg
+	ExitAtEnd        bool
+	MaxMessages      int
+	IdleTimeout      time.Duration
+	Group            string
+	Commit           bool
+}
+
+// HeaderGrep matches the messages with a header of the given name whose value matches the pattern.
+type HeaderGrep struct {
+	Name, Pattern string
+}
+
+type headerGrep struct {
+	name    string
+	pattern *regexp.Regexp
 }
 
 // Kafka is the consumer itself.
 type Kafka struct {
 	ctx context.Context
 
-	topic   string
+	topics  []string
 	offsets []offsets
 
-	keyGrep *regexp.Regexp
-	verbose bool
+	// start, end, partitionStarts and exitAtEnd are the configured offsets, resolved for each partition by partitionOffsets
+	start, end      Offset
+	partitionStarts map[int32]Offset
+	exitAtEnd       bool
+
+	group  string
+	commit bool
+
+	keyGrep     *regexp.Regexp
+	headerGreps []headerGrep
+	verbose     bool
+
+	filters       []*filter.Expr
+	invertFilters bool
+
+	limits *limits
 
 	client sarama.Client
 
-	decoder protoparser.Decoder
-	printer output.Printer
+	decoder    MessageDecoder
+	keyDecoder protoparser.Decoder
+	printer    output.Printer
 }
 
 type offsets struct {
+	topic      string
 	partition  int32
 	start, end int64
 }
 
 // NewKafka returns a new instance of this consumer or an error if something isn't right.
-func NewKafka(ctx context.Context, cfg Cfg, decoder protoparser.Decoder, printer output.Printer) (*Kafka, error) {
-	config := sarama.NewConfig()
-	config.ClientID = "proton-consumer"
-	config.Consumer.Return.Errors = true
-	config.Version = sarama.V0_11_0_0
-	config.Consumer.IsolationLevel = sarama.ReadCommitted
+// Keys are decoded with the key decoder, if given, so that the key pattern is matched against the decoded keys.
+func NewKafka(ctx context.Context, cfg Cfg, decoder MessageDecoder, keyDecoder protoparser.Decoder, printer output.Printer) (*Kafka, error) {
+	brokers, config, err := newConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	if cfg.Verbose {
-		fmt.Println("Spinning the wheel... Connecting, gathering partitions data and stuff...")
-		fmt.Println(fmt.Sprintf("Consuming from %s from timestamp %d until timestamp %d", cfg.Topic, cfg.Start, cfg.End))
+		fmt.Fprintln(os.Stderr, "Spinning the wheel... Connecting, gathering partitions data and stuff...")
+		fmt.Fprintf(os.Stderr, "Consuming from %s from %s until %s\n", strings.Join(cfg.Topics, ", "), cfg.Start, cfg.End)
 	}
 
-	parsed, err := url.Parse(cfg.URL)
+	client, err := sarama.NewClient(brokers, config)
 	if err != nil {
+		if config.Net.TLS.Enable {
+			if tlsErr := diagnoseTLS(brokers, config.Net.TLS.Config); tlsErr != nil {
+				return nil, tlsErr
+			}
+		}
 		return nil, err
 	}
 
-	broker := parsed.String()
-	if parsed.Port() == "" {
-		broker = fmt.Sprintf("%s:%s", broker, defaultPort)
-	}
-
-	client, err := sarama.NewClient([]string{broker}, config)
+	topics, err := resolveTopics(client, cfg.Topics)
 	if err != nil {
 		return nil, err
 	}
 
-	var oo []offsets
-	topic := cfg.Topic
-	partitions, err := client.Partitions(topic)
+	k, err := newKafka(ctx, cfg, decoder, keyDecoder, printer)
 	if err != nil {
 		return nil, err
 	}
+	k.topics = topics
+	k.client = client
 
-	for _, p := range partitions {
-		start := sarama.OffsetOldest
-		if cfg.Start != sarama.OffsetOldest {
-			start, err = client.GetOffset(topic, p, cfg.Start)
-			if err != nil {
-				fmt.Println(err)
-				return nil, err
-			}
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, err
 		}
 
-		end := sarama.OffsetNewest
-		if cfg.End != sarama.OffsetNewest {
-			end, err = client.GetOffset(topic, p, cfg.End)
+		for _, p := range partitions {
+			o, err := k.partitionOffsets(topic, p)
 			if err != nil {
-				fmt.Println(err)
 				return nil, err
 			}
+			k.offsets = append(k.offsets, o)
 		}
+	}
+
+	return k, nil
+}
 
-		oo = append(oo, offsets{partition: p, start: start, end: end})
+// NewProcessor returns a consumer without a Kafka client, which only processes the messages it's given with Process,
+// e.g. the records of a log segment. The message count limit of the configuration applies, the offsets don't.
+func NewProcessor(ctx context.Context, cfg Cfg, decoder MessageDecoder, keyDecoder protoparser.Decoder, printer output.Printer) (*Kafka, error) {
+	k, err := newKafka(ctx, cfg, decoder, keyDecoder, printer)
+	if err != nil {
+		return nil, err
 	}
+	k.ctx, k.limits.stop = context.WithCancel(ctx)
+	return k, nil
+}
 
+// newKafka returns a consumer with the patterns and the filters of the configuration compiled.
+func newKafka(ctx context.Context, cfg Cfg, decoder MessageDecoder, keyDecoder protoparser.Decoder, printer output.Printer) (*Kafka, error) {
 	keyGrep, err := regexp.Compile(cfg.KeyGrep)
 	if err != nil {
 		return nil, err
 	}
 
+	headerGreps := make([]headerGrep, 0, len(cfg.HeaderGreps))
+	for _, g := range cfg.HeaderGreps {
+		pattern, err := regexp.Compile(g.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for header %s: %w", g.Name, err)
+		}
+		headerGreps = append(headerGreps, headerGrep{name: g.Name, pattern: pattern})
+	}
+
+	filters := make([]*filter.Expr, 0, len(cfg.Filters))
+	for _, f := range cfg.Filters {
+		expr, err := filter.Parse(f, cfg.FilterIgnoreCase)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, expr)
+	}
+
 	return &Kafka{
-		ctx:     ctx,
-		topic:   topic,
-		offsets: oo,
-		keyGrep: keyGrep,
-		verbose: cfg.Verbose,
-		client:  client,
-		decoder: decoder,
-		printer: printer,
+		ctx:             ctx,
+		start:           cfg.Start,
+		end:             cfg.End,
+		partitionStarts: cfg.PartitionStarts,
+		exitAtEnd:       cfg.ExitAtEnd,
+		group:           cfg.Group,
+		commit:          cfg.Commit,
+		keyGrep:         keyGrep,
+		headerGreps:     headerGreps,
+		verbose:         cfg.Verbose,
+		filters:         filters,
+		invertFilters:   cfg.InvertFilters,
+		limits:          newLimits(cfg.MaxMessages, cfg.IdleTimeout),
+		decoder:         decoder,
+		keyDecoder:      keyDecoder,
+		printer:         printer,
 	}, nil
 }
 
+// partitionOffsets resolves the configured start and end offsets of a partition.
+func (k *Kafka) partitionOffsets(topic string, p int32) (offsets, error) {
+	startOffset, ok := k.partitionStarts[p]
+	if !ok {
+		startOffset = k.start
+	}
+
+	start, err := partitionOffset(k.client, topic, p, startOffset)
+	if err != nil {
+		return offsets{}, fmt.Errorf("resolving the start offset of %s [%d]: %w", topic, p, err)
+	}
+
+	end, err := partitionOffset(k.client, topic, p, k.end)
+	if err != nil {
+		return offsets{}, fmt.Errorf("resolving the end offset of %s [%d]: %w", topic, p, err)
+	}
+
+	if k.exitAtEnd {
+		highWaterMark, err := k.client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return offsets{}, fmt.Errorf("resolving the end offset of %s [%d]: %w", topic, p, err)
+		}
+
+		if end == sarama.OffsetNewest || end > highWaterMark {
+			end = highWaterMark
+		}
+	}
+
+	return offsets{topic: topic, partition: p, start: start, end: end}, nil
+}
+
 // Run runs the consumer and consumes everything according to its configuration.
 // If any [infra] error happens before we even started, it gets written to the output error channel.
 // If any [parsing] error happens during the consumption, it's given to a printer.
-// When consumer reaches the configured end offset, it stops. Otherwise, it keeps waiting for new messages.
-// All consumers will stop if the consumer context is cancelled.
+// When consumer reaches the configured end offset of all partitions, it stops and closes the error channel.
+// Otherwise, it keeps waiting for new messages.
+// In consumer group mode, it only consumes the partitions assigned to it, and stops when they all reach their end.
+// All consumers will stop if the consumer context is cancelled, or once the message count or idle limit is hit.
 func (k *Kafka) Run() <-chan error {
 	errCh := make(chan error)
 
 	go func() {
-		wg := sync.WaitGroup{}
+		defer close(errCh)
 
-		consumer, err := sarama.NewConsumerFromClient(k.client)
-		if err != nil {
+		ctx, cancel := context.WithCancel(k.ctx)
+		defer cancel()
+
+		k.limits.stop = cancel
+		go k.watchIdle(ctx)
+
+		consume := k.consumePartitions
+		if k.group != "" {
+			consume = k.consumeGroup
+		}
+
+		if err := consume(ctx); err != nil {
 			errCh <- err
-			return
 		}
+	}()
+
+	return errCh
+}
+
+// consumePartitions consumes all the partitions directly, without a consumer group.
+func (k *Kafka) consumePartitions(ctx context.Context) error {
+	consumer, err := sarama.NewConsumerFromClient(k.client)
+	if err != nil {
+		return err
+	}
+
+	// the first partition that fails stops all the others
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		failOnce sync.Once
+		failErr  error
+	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			cancel()
+		})
+	}
+
+	wg := sync.WaitGroup{}
+
+	for _, o := range k.offsets {
+		wg.Add(1)
+		go func(o offsets) {
+			defer wg.Done()
 
-		defer func() {
-			if err := consumer.Close(); err != nil {
-				errCh <- err
+			if err := k.consumePartition(ctx, consumer, o); err != nil {
+				fail(err)
 			}
-		}()
+		}(o)
+	}
 
-		for _, o := range k.offsets {
-			wg.Add(1)
-			go func(topic string, o offsets) {
-				defer wg.Done()
+	wg.Wait()
 
-				k.log(fmt.Sprintf("# Going to consume from %s until %s", offsetMsg(topic, o.partition, o.start), offsetMsg(topic, o.partition, o.end)))
+	if err := consumer.Close(); err != nil && failErr == nil {
+		return err
+	}
 
-				c, err := consumer.ConsumePartition(topic, o.partition, o.start)
-				if err != nil {
-					errCh <- err
-					return
-				}
+	return failErr
+}
 
-				for {
-					select {
-					case <-k.ctx.Done():
-						return
-					case message := <-c.Messages():
-						k.processMessage(message)
-
-						if o.end == message.Offset {
-							k.log(fmt.Sprintf("# Reached stop timestamp for topic %s: exiting", offsetMsg(topic, o.partition, o.end)))
-							return
-						}
-
-						if message.Offset+1 == c.HighWaterMarkOffset() {
-							k.log(fmt.Sprintf("# Reached stop timestamp for topic %s", offsetMsg(topic, o.partition, o.end)))
-						}
-					}
-				}
-			}(k.topic, o)
+// consumePartition consumes a single partition until its end offset, if it has one, or until the context is cancelled.
+func (k *Kafka) consumePartition(ctx context.Context, consumer sarama.Consumer, o offsets) error {
+	topic := o.topic
+
+	k.log(fmt.Sprintf("# Going to consume from %s until %s", offsetMsg(topic, o.partition, o.start), offsetMsg(topic, o.partition, o.end)))
+
+	if o.end >= 0 {
+		start, err := k.absoluteOffset(topic, o.partition, o.start)
+		if err != nil {
+			return err
 		}
 
-		wg.Wait()
-		close(errCh)
+		if start >= o.end {
+			k.log(fmt.Sprintf("# Nothing to consume before %s: exiting", offsetMsg(topic, o.partition, o.end)))
+			return nil
+		}
+	}
+
+	c, err := consumer.ConsumePartition(topic, o.partition, o.start)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
 	}()
 
-	return errCh
+	// Transaction markers and aborted messages are never received, so when they're the last ones before the end,
+	// the partition is done once no message is received within a fetch and nothing was written past the end.
+	var caughtUp <-chan time.Time
+	resetCaughtUp := func() {}
+	if o.end >= 0 {
+		wait := k.client.Config().Consumer.MaxWaitTime
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		caughtUp = timer.C
+		resetCaughtUp = func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-c.Errors():
+			k.printer.PrintErr(err)
+		case <-caughtUp:
+			if c.HighWaterMarkOffset() <= o.end {
+				k.log(fmt.Sprintf("# Reached end of %s, past the last message: exiting", offsetMsg(topic, o.partition, o.end)))
+				return nil
+			}
+			resetCaughtUp()
+		case message := <-c.Messages():
+			resetCaughtUp()
+
+			// the end offset isn't included, and offsets can be skipped by compaction or transaction markers
+			if o.end >= 0 && message.Offset >= o.end {
+				k.log(fmt.Sprintf("# Reached end of %s: exiting", offsetMsg(topic, o.partition, o.end)))
+				return nil
+			}
+
+			k.processMessage(message)
+
+			if o.end >= 0 && message.Offset+1 >= o.end {
+				k.log(fmt.Sprintf("# Reached end of %s: exiting", offsetMsg(topic, o.partition, o.end)))
+				return nil
+			}
+
+			if message.Offset+1 == c.HighWaterMarkOffset() {
+				k.log(fmt.Sprintf("# Reached the current end of %s, waiting for new messages", offsetMsg(topic, o.partition, c.HighWaterMarkOffset())))
+			}
+		}
+	}
+}
+
+// resolveTopics expands the topic patterns against the cluster's metadata, only fetching it when there are patterns.
+func resolveTopics(client sarama.Client, topics []string) ([]string, error) {
+	var available []string
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, topicPatternPrefix) {
+			var err error
+			available, err = client.Topics()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	return expandTopics(topics, available)
+}
+
+// expandTopics returns the given topics, with the patterns among them replaced by the available topics matching them.
+func expandTopics(topics, available []string) ([]string, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("no topics to consume from")
+	}
+
+	sort.Strings(available)
+
+	var res []string
+	seen := map[string]bool{}
+	add := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			res = append(res, topic)
+		}
+	}
+
+	for _, topic := range topics {
+		if !strings.HasPrefix(topic, topicPatternPrefix) {
+			add(topic)
+			continue
+		}
+
+		pattern, err := regexp.Compile(topic)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic pattern %s: %w", topic, err)
+		}
+
+		matched := false
+		for _, a := range available {
+			if !strings.HasPrefix(a, internalTopicPrefix) && pattern.MatchString(a) {
+				matched = true
+				add(a)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no topics match the pattern %s", topic)
+		}
+	}
+
+	return res, nil
+}
+
+// Process processes a message of a consumer created with NewProcessor, i.e. decodes, filters and prints it.
+// It returns false once no more messages should be given, e.g. once the message count limit is reached.
+func (k *Kafka) Process(message *sarama.ConsumerMessage) bool {
+	if k.ctx.Err() != nil {
+		return false
+	}
+
+	k.processMessage(message)
+	return k.ctx.Err() == nil
 }
 
 func (k *Kafka) processMessage(message *sarama.ConsumerMessage) {
-	if k.keyGrep.Match(message.Key) {
-		msg, err := k.decoder.Decode(message.Value)
-		if err == nil {
-			k.printer.Print(output.Msg{
-				Key:       string(message.Key),
-				Value:     msg,
-				Topic:     message.Topic,
-				Partition: int(message.Partition),
-				Offset:    int(message.Offset),
-				Time:      message.Timestamp,
-			})
-		} else {
-			k.printer.PrintErr(err)
+	k.limits.receive()
+
+	key, err := k.decodeKey(message)
+	if err != nil {
+		k.printer.PrintErr(err)
+		return
+	}
+
+	if !k.matches(key, message) {
+		return
+	}
+
+	msg, err := k.decoder.DecodeMessage(message)
+	if err != nil {
+		k.printer.PrintErr(&output.MsgError{Msg: newMsg(message, key, ""), Raw: message.Value, Err: err})
+		return
+	}
+
+	ok, err := k.matchesFilters(msg)
+	if err != nil {
+		k.printer.PrintErr(fmt.Errorf("filtering %s: %w", offsetMsg(message.Topic, message.Partition, message.Offset), err))
+		return
+	}
+
+	if ok && k.limits.take() {
+		k.printer.Print(newMsg(message, key, msg))
+	}
+}
+
+func newMsg(message *sarama.ConsumerMessage, key, value string) output.Msg {
+	return output.Msg{
+		Key:       key,
+		Value:     value,
+		KeySize:   size(message.Key),
+		ValueSize: size(message.Value),
+		Topic:     message.Topic,
+		Partition: int(message.Partition),
+		Offset:    int(message.Offset),
+		Time:      message.Timestamp,
+		Headers:   headers(message.Headers),
+	}
+}
+
+// matchesFilters tells whether the decoded message matches all the filters, or doesn't if the filters are inverted.
+func (k *Kafka) matchesFilters(msg string) (bool, error) {
+	if len(k.filters) == 0 {
+		return true, nil
+	}
+
+	d := json.NewDecoder(strings.NewReader(msg))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return false, fmt.Errorf("the message isn't json: %w", err)
+	}
+
+	matched := true
+	for _, f := range k.filters {
+		if !f.MatchValue(v) {
+			matched = false
+			break
+		}
+	}
+
+	return matched != k.invertFilters, nil
+}
+
+// decodeKey decodes the message key with the key decoder, if there's one. Messages without a key have an empty key.
+func (k *Kafka) decodeKey(message *sarama.ConsumerMessage) (string, error) {
+	if k.keyDecoder == nil || message.Key == nil {
+		return string(message.Key), nil
+	}
+
+	key, err := k.keyDecoder.Decode(message.Key)
+	if err != nil {
+		return "", fmt.Errorf("decoding the key of %s: %w", offsetMsg(message.Topic, message.Partition, message.Offset), err)
+	}
+	return key, nil
+}
+
+// matches tells whether the key matches the key pattern and the message matches all the header patterns.
+func (k *Kafka) matches(key string, message *sarama.ConsumerMessage) bool {
+	if !k.keyGrep.MatchString(key) {
+		return false
+	}
+
+	for _, g := range k.headerGreps {
+		if !hasHeader(message.Headers, g) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasHeader tells whether any of the headers with the name of the grep matches its pattern.
+func hasHeader(headers []*sarama.RecordHeader, g headerGrep) bool {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == g.name && g.pattern.Match(h.Value) {
+			return true
 		}
 	}
+	return false
+}
+
+func headers(rh []*sarama.RecordHeader) []output.Header {
+	if len(rh) == 0 {
+		return nil
+	}
+
+	res := make([]output.Header, 0, len(rh))
+	for _, h := range rh {
+		if h != nil {
+			res = append(res, output.Header{Key: string(h.Key), Value: string(h.Value)})
+		}
+	}
+	return res
+}
+
+// size returns the size of a key or value, -1 if it's null.
+func size(b []byte) int {
+	if b == nil {
+		return -1
+	}
+	return len(b)
 }
 
 func offsetMsg(topic string, partition int32, offset int64) string {
@@ -210,8 +612,9 @@ func offsetMsg(topic string, partition int32, offset int64) string {
 	return fmt.Sprintf("%s [%d] at offset %s", topic, partition, om)
 }
 
+// log prints the message to stderr in verbose mode, apart from the messages printed to stdout.
 func (k *Kafka) log(msg string) {
 	if k.verbose {
-		fmt.Println(msg)
+		fmt.Fprintln(os.Stderr, msg)
 	}
 }
@@ -0,0 +1,570 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/beatlabs/proton/v2/internal/filter"
+	"github.com/beatlabs/proton/v2/internal/output"
+	"github.com/beatlabs/proton/v2/internal/protoparser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandTopics(t *testing.T) {
+	available := []string{"payments", "orders.created", "__consumer_offsets", "orders.cancelled", "users"}
+
+	tests := []struct {
+		name     string
+		topics   []string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "plain topics are kept as they are",
+			topics:   []string{"orders", "payments"},
+			expected: []string{"orders", "payments"},
+		},
+		{
+			name:     "pattern is expanded in alphabetical order",
+			topics:   []string{"^orders\\..*"},
+			expected: []string{"orders.cancelled", "orders.created"},
+		},
+		{
+			name:     "duplicates are removed",
+			topics:   []string{"orders.created", "^orders\\..*", "users"},
+			expected: []string{"orders.created", "orders.cancelled", "users"},
+		},
+		{
+			name:     "internal topics are never matched",
+			topics:   []string{"^.*"},
+			expected: []string{"orders.cancelled", "orders.created", "payments", "users"},
+		},
+		{
+			name:   "no topics",
+			topics: []string{},
+			err:    "no topics to consume from",
+		},
+		{
+			name:   "nothing matches the pattern",
+			topics: []string{"^refunds"},
+			err:    "no topics match the pattern ^refunds",
+		},
+		{
+			name:   "invalid pattern",
+			topics: []string{"^orders("},
+			err:    "invalid topic pattern ^orders(: error parsing regexp: missing closing ): `^orders(`",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			res, err := expandTopics(test.topics, append([]string{}, available...))
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+// valueDecoder decodes message values as plain strings.
+type valueDecoder struct{}
+
+func (valueDecoder) DecodeMessage(message *sarama.ConsumerMessage) (string, error) {
+	return string(message.Value), nil
+}
+
+// failingDecoder fails to decode anything.
+type failingDecoder struct{}
+
+func (failingDecoder) Decode([]byte) (string, error) {
+	return "", errors.New("b00m")
+}
+
+func (failingDecoder) DecodeMessage(*sarama.ConsumerMessage) (string, error) {
+	return "", errors.New("b00m")
+}
+
+// collectingPrinter keeps everything printed, safe for concurrent use.
+type collectingPrinter struct {
+	mu   sync.Mutex
+	msgs []output.Msg
+	errs []error
+}
+
+func (c *collectingPrinter) Print(msg output.Msg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+}
+
+func (c *collectingPrinter) PrintErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+func (c *collectingPrinter) values() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var res []string
+	for _, m := range c.msgs {
+		res = append(res, m.Value)
+	}
+	return res
+}
+
+// newMockCluster returns a broker with a single partition topic holding the messages foo and bar at offsets 0 and 1.
+// Extra handlers can be added for more than consuming partitions.
+func newMockCluster(t *testing.T, extra func(*sarama.MockBroker) map[string]sarama.MockResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("my-topic", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("my-topic", 0, 1646218065015, 1).
+			SetOffset("my-topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("my-topic", 0, sarama.OffsetNewest, 2),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).SetVersion(4).
+			SetMessage("my-topic", 0, 0, sarama.StringEncoder("foo")).
+			SetMessage("my-topic", 0, 1, sarama.StringEncoder("bar")).
+			SetHighWaterMark("my-topic", 0, 2),
+	}
+	if extra != nil {
+		for k, v := range extra(broker) {
+			handlers[k] = v
+		}
+	}
+	broker.SetHandlerByMap(handlers)
+
+	return broker
+}
+
+func TestKafka_RunUntilEnd(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    Offset
+		end      Offset
+		expected []string
+	}{
+		{
+			name:     "exit at the high watermark",
+			end:      Offset{Kind: OffsetEnd},
+			expected: []string{"foo", "bar"},
+		},
+		{
+			name:     "end time comes before the high watermark and isn't included",
+			end:      Offset{Kind: OffsetTime, Value: 1646218065015},
+			expected: []string{"foo"},
+		},
+		{
+			name:  "nothing to consume",
+			start: Offset{Kind: OffsetEnd},
+			end:   Offset{Kind: OffsetEnd},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			broker := newMockCluster(t, nil)
+			defer broker.Close()
+
+			printer := &collectingPrinter{}
+			k, err := NewKafka(context.Background(), Cfg{
+				URL:       broker.Addr(),
+				Topics:    []string{"my-topic"},
+				Start:     test.start,
+				End:       test.end,
+				KeyGrep:   ".*",
+				ExitAtEnd: true,
+			}, valueDecoder{}, nil, printer)
+			require.NoError(t, err)
+
+			// when
+			errCh := k.Run()
+
+			// then
+			select {
+			case err, ok := <-errCh:
+				assert.NoError(t, err)
+				assert.False(t, ok, "the error channel should be closed")
+			case <-time.After(5 * time.Second):
+				t.Fatal("consumer didn't stop at the end")
+			}
+			assert.Equal(t, test.expected, printer.values())
+			assert.Empty(t, printer.errs)
+		})
+	}
+}
+
+func TestKafka_RunUntilEndWithTransactionMarker(t *testing.T) {
+	// given
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecordBatch("my-topic", 0, nil, sarama.StringEncoder("foo"), 0, 7, true)
+	fetch.AddRecordBatch("my-topic", 0, nil, sarama.StringEncoder("bar"), 1, 7, true)
+	// the commit marker is the last offset before the high watermark, and it's never received
+	fetch.AddControlRecord("my-topic", 0, 2, 7, sarama.ControlRecordCommit)
+	fetch.Blocks["my-topic"][0].HighWaterMarkOffset = 3
+	fetch.Blocks["my-topic"][0].LastStableOffset = 3
+
+	broker := newMockCluster(t, func(*sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+				SetOffset("my-topic", 0, sarama.OffsetOldest, 0).
+				SetOffset("my-topic", 0, sarama.OffsetNewest, 3),
+			"FetchRequest": sarama.NewMockWrapper(fetch),
+		}
+	})
+	defer broker.Close()
+
+	printer := &collectingPrinter{}
+	k, err := NewKafka(context.Background(), Cfg{
+		URL:       broker.Addr(),
+		Topics:    []string{"my-topic"},
+		End:       Offset{Kind: OffsetEnd},
+		KeyGrep:   ".*",
+		ExitAtEnd: true,
+	}, valueDecoder{}, nil, printer)
+	require.NoError(t, err)
+
+	// when
+	errCh := k.Run()
+
+	// then
+	select {
+	case err, ok := <-errCh:
+		assert.NoError(t, err)
+		assert.False(t, ok, "the error channel should be closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer didn't stop at the end")
+	}
+	assert.Equal(t, []string{"foo", "bar"}, printer.values())
+	assert.Empty(t, printer.errs)
+}
+
+func TestKafka_RunLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxMessages int
+		idleTimeout time.Duration
+		expected    []string
+	}{
+		{
+			name:        "stop after the message count",
+			maxMessages: 1,
+			expected:    []string{"foo"},
+		},
+		{
+			name:        "stop once idle",
+			idleTimeout: 200 * time.Millisecond,
+			expected:    []string{"foo", "bar"},
+		},
+		{
+			name:        "message count above the available messages stops once idle",
+			maxMessages: 5,
+			idleTimeout: 200 * time.Millisecond,
+			expected:    []string{"foo", "bar"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			broker := newMockCluster(t, nil)
+			defer broker.Close()
+
+			printer := &collectingPrinter{}
+			k, err := NewKafka(context.Background(), Cfg{
+				URL:         broker.Addr(),
+				Topics:      []string{"my-topic"},
+				End:         Offset{Kind: OffsetEnd},
+				KeyGrep:     ".*",
+				MaxMessages: test.maxMessages,
+				IdleTimeout: test.idleTimeout,
+			}, valueDecoder{}, nil, printer)
+			require.NoError(t, err)
+
+			// when
+			errCh := k.Run()
+
+			// then
+			select {
+			case err, ok := <-errCh:
+				assert.NoError(t, err)
+				assert.False(t, ok, "the error channel should be closed")
+			case <-time.After(5 * time.Second):
+				t.Fatal("consumer didn't stop at the limit")
+			}
+			assert.Equal(t, test.expected, printer.values())
+			assert.Empty(t, printer.errs)
+		})
+	}
+}
+
+func TestKafka_ProcessMessage(t *testing.T) {
+	headers := []*sarama.RecordHeader{
+		{Key: []byte("trace-id"), Value: []byte("abc")},
+		{Key: []byte("ce-type"), Value: []byte("order.created")},
+	}
+
+	tests := []struct {
+		name        string
+		keyGrep     string
+		headerGreps []HeaderGrep
+		keyDecoder  protoparser.Decoder
+		expected    []output.Msg
+		err         string
+	}{
+		{
+			name:    "headers are carried to the printer",
+			keyGrep: ".*",
+			expected: []output.Msg{{
+				Key:       "my-key",
+				Value:     "my-value",
+				KeySize:   6,
+				ValueSize: 8,
+				Topic:     "my-topic",
+				Headers: []output.Header{
+					{Key: "trace-id", Value: "abc"},
+					{Key: "ce-type", Value: "order.created"},
+				},
+			}},
+		},
+		{
+			name:        "key and header match",
+			keyGrep:     "my-.*",
+			headerGreps: []HeaderGrep{{Name: "ce-type", Pattern: `^order\.`}, {Name: "trace-id", Pattern: "abc"}},
+			expected: []output.Msg{{
+				Key:       "my-key",
+				Value:     "my-value",
+				KeySize:   6,
+				ValueSize: 8,
+				Topic:     "my-topic",
+				Headers: []output.Header{
+					{Key: "trace-id", Value: "abc"},
+					{Key: "ce-type", Value: "order.created"},
+				},
+			}},
+		},
+		{
+			name:        "header value doesn't match",
+			keyGrep:     ".*",
+			headerGreps: []HeaderGrep{{Name: "ce-type", Pattern: `^payment\.`}},
+		},
+		{
+			name:        "header is missing",
+			keyGrep:     ".*",
+			headerGreps: []HeaderGrep{{Name: "schema-version", Pattern: ".*"}},
+		},
+		{
+			name:    "key doesn't match",
+			keyGrep: "other-key",
+		},
+		{
+			name:       "decoded key matches",
+			keyGrep:    "^decoded:my-",
+			keyDecoder: namedDecoder("decoded"),
+			expected: []output.Msg{{
+				Key:       "decoded:my-key",
+				Value:     "my-value",
+				KeySize:   6,
+				ValueSize: 8,
+				Topic:     "my-topic",
+				Headers: []output.Header{
+					{Key: "trace-id", Value: "abc"},
+					{Key: "ce-type", Value: "order.created"},
+				},
+			}},
+		},
+		{
+			name:       "raw key doesn't match once decoded",
+			keyGrep:    "^my-key$",
+			keyDecoder: namedDecoder("decoded"),
+		},
+		{
+			name:       "key fails to decode",
+			keyGrep:    ".*",
+			keyDecoder: failingDecoder{},
+			err:        "decoding the key of my-topic [0] at offset 0: b00m",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var greps []headerGrep
+			for _, g := range test.headerGreps {
+				greps = append(greps, headerGrep{name: g.Name, pattern: regexp.MustCompile(g.Pattern)})
+			}
+
+			printer := &collectingPrinter{}
+			k := &Kafka{
+				keyGrep:     regexp.MustCompile(test.keyGrep),
+				headerGreps: greps,
+				limits:      newLimits(0, 0),
+				decoder:     valueDecoder{},
+				keyDecoder:  test.keyDecoder,
+				printer:     printer,
+			}
+
+			// when
+			k.processMessage(&sarama.ConsumerMessage{
+				Key:     []byte("my-key"),
+				Value:   []byte("my-value"),
+				Topic:   "my-topic",
+				Headers: headers,
+			})
+
+			// then
+			assert.Equal(t, test.expected, printer.msgs)
+			if test.err != "" {
+				require.Len(t, printer.errs, 1)
+				assert.EqualError(t, printer.errs[0], test.err)
+				return
+			}
+			assert.Empty(t, printer.errs)
+		})
+	}
+}
+
+func TestKafka_ProcessMessageFilters(t *testing.T) {
+	values := []string{
+		`{"person":{"email":"x@y"}}`,
+		`{"person":{"email":"X@Y"}}`,
+		`{"person":{"email":"a@b"}}`,
+		`not json`,
+	}
+
+	tests := []struct {
+		name       string
+		filters    []string
+		invert     bool
+		ignoreCase bool
+		expected   []string
+		errs       int
+	}{
+		{
+			name:     "no filters",
+			expected: values,
+		},
+		{
+			name:     "matching filter",
+			filters:  []string{`.person.email == "x@y"`},
+			expected: []string{values[0]},
+			errs:     1,
+		},
+		{
+			name:       "case-insensitive filter",
+			filters:    []string{`.person.email == "x@y"`},
+			ignoreCase: true,
+			expected:   []string{values[0], values[1]},
+			errs:       1,
+		},
+		{
+			name:     "inverted filter",
+			filters:  []string{`.person.email == "x@y"`},
+			invert:   true,
+			expected: []string{values[1], values[2]},
+			errs:     1,
+		},
+		{
+			name:     "all filters must match",
+			filters:  []string{`.person.email =~ "@"`, `.person.email =~ "^a"`},
+			expected: []string{values[2]},
+			errs:     1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			var filters []*filter.Expr
+			for _, f := range test.filters {
+				expr, err := filter.Parse(f, test.ignoreCase)
+				require.NoError(t, err)
+				filters = append(filters, expr)
+			}
+
+			printer := &collectingPrinter{}
+			k := &Kafka{
+				keyGrep:       regexp.MustCompile(".*"),
+				filters:       filters,
+				invertFilters: test.invert,
+				limits:        newLimits(0, 0),
+				decoder:       valueDecoder{},
+				printer:       printer,
+			}
+
+			// when
+			for i, v := range values {
+				k.processMessage(&sarama.ConsumerMessage{Topic: "my-topic", Offset: int64(i), Value: []byte(v)})
+			}
+
+			// then
+			assert.Equal(t, test.expected, printer.values())
+			assert.Len(t, printer.errs, test.errs)
+		})
+	}
+}
+
+func TestKafka_ProcessMessageDecodeError(t *testing.T) {
+	// given
+	printer := &collectingPrinter{}
+	k := &Kafka{
+		keyGrep: regexp.MustCompile(".*"),
+		limits:  newLimits(0, 0),
+		decoder: failingDecoder{},
+		printer: printer,
+	}
+
+	// when
+	k.processMessage(&sarama.ConsumerMessage{Topic: "my-topic", Partition: 3, Offset: 42, Value: []byte{0x0a, 0xff}})
+
+	// then
+	assert.Empty(t, printer.msgs)
+	require.Len(t, printer.errs, 1)
+
+	var msgErr *output.MsgError
+	require.ErrorAs(t, printer.errs[0], &msgErr)
+	assert.EqualError(t, msgErr, "b00m")
+	assert.Equal(t, []byte{0x0a, 0xff}, msgErr.Raw)
+	assert.Equal(t, output.Msg{KeySize: -1, ValueSize: 2, Topic: "my-topic", Partition: 3, Offset: 42}, msgErr.Msg)
+}
+
+func TestKafka_Process(t *testing.T) {
+	// given
+	printer := &collectingPrinter{}
+	k, err := NewProcessor(context.Background(), Cfg{KeyGrep: "^a", MaxMessages: 2}, valueDecoder{}, nil, printer)
+	require.NoError(t, err)
+
+	messages := []*sarama.ConsumerMessage{
+		{Key: []byte("a1"), Value: []byte("v1")},
+		{Key: []byte("b2"), Value: []byte("v2")},
+		{Key: []byte("a3"), Value: []byte("v3")},
+		{Key: []byte("a4"), Value: []byte("v4")},
+	}
+
+	// when
+	var processed []bool
+	for _, m := range messages {
+		processed = append(processed, k.Process(m))
+	}
+
+	// then
+	assert.Equal(t, []bool{true, true, false, false}, processed)
+	assert.Equal(t, []string{"v1", "v3"}, printer.values())
+}
+
+func TestNewProcessor_InvalidPattern(t *testing.T) {
+	_, err := NewProcessor(context.Background(), Cfg{HeaderGreps: []HeaderGrep{{Name: "h", Pattern: "("}}}, valueDecoder{}, nil, &collectingPrinter{})
+	assert.Error(t, err)
+}
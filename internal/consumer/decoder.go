@@ -0,0 +1,154 @@
+package consumer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/beatlabs/proton/v2/internal/protoparser"
+)
+
+// MessageDecoder decodes the value of a consumed Kafka message.
+type MessageDecoder interface {
+	DecodeMessage(*sarama.ConsumerMessage) (string, error)
+}
+
+// TopicDecoder decodes the messages of each topic with the decoder mapped to it.
+// Topics without a mapping are decoded with the default decoder.
+type TopicDecoder struct {
+	fallback protoparser.Decoder
+	topics   map[string]protoparser.Decoder
+	patterns []topicPattern
+
+	mu       sync.Mutex
+	resolved map[string]protoparser.Decoder
+}
+
+type topicPattern struct {
+	pattern *regexp.Regexp
+	decoder protoparser.Decoder
+}
+
+// NewTopicDecoder returns a new decoder that uses the given default decoder for topics without a mapping.
+// The default decoder can be nil, in which case messages of topics without a mapping fail to decode.
+func NewTopicDecoder(fallback protoparser.Decoder) *TopicDecoder {
+	return &TopicDecoder{
+		fallback: fallback,
+		topics:   map[string]protoparser.Decoder{},
+		resolved: map[string]protoparser.Decoder{},
+	}
+}
+
+// Add maps a topic, or a topic pattern starting with "^", to a decoder.
+// Topics take precedence over patterns, and patterns are tried in the order they were added.
+func (t *TopicDecoder) Add(topic string, decoder protoparser.Decoder) error {
+	if !strings.HasPrefix(topic, topicPatternPrefix) {
+		t.topics[topic] = decoder
+		return nil
+	}
+
+	pattern, err := regexp.Compile(topic)
+	if err != nil {
+		return fmt.Errorf("invalid topic pattern %s: %w", topic, err)
+	}
+
+	t.patterns = append(t.patterns, topicPattern{pattern: pattern, decoder: decoder})
+	return nil
+}
+
+// DecodeMessage decodes the message value with the decoder mapped to the message's topic.
+func (t *TopicDecoder) DecodeMessage(message *sarama.ConsumerMessage) (string, error) {
+	decoder := t.decoder(message.Topic)
+	if decoder == nil {
+		return "", fmt.Errorf("no message type is mapped to topic %s", message.Topic)
+	}
+
+	return decoder.Decode(message.Value)
+}
+
+func (t *TopicDecoder) decoder(topic string) protoparser.Decoder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if d, ok := t.resolved[topic]; ok {
+		return d
+	}
+
+	d, ok := t.topics[topic]
+	if !ok {
+		d = t.fallback
+		for _, p := range t.patterns {
+			if p.pattern.MatchString(topic) {
+				d = p.decoder
+				break
+			}
+		}
+	}
+
+	t.resolved[topic] = d
+	return d
+}
+
+// HeaderDecoder decodes each message with the decoder of the message type named in one of its headers.
+// Header values are taken as fully qualified message types, unless they are mapped to a message type.
+// Messages without the header are decoded with the fallback decoder.
+type HeaderDecoder struct {
+	header   string
+	decoders map[string]protoparser.Decoder
+	types    map[string]string
+	fallback MessageDecoder
+}
+
+// NewHeaderDecoder returns a new decoder that picks the message type from the given header,
+// among the decoders keyed by their fully qualified message type.
+func NewHeaderDecoder(header string, decoders map[string]protoparser.Decoder, fallback MessageDecoder) *HeaderDecoder {
+	return &HeaderDecoder{
+		header:   header,
+		decoders: decoders,
+		types:    map[string]string{},
+		fallback: fallback,
+	}
+}
+
+// Map maps a header value, e.g. a CloudEvents type, to a fully qualified message type.
+func (h *HeaderDecoder) Map(value, messageType string) error {
+	if _, ok := h.decoders[messageType]; !ok {
+		return fmt.Errorf("unknown message type %s mapped to header value %s", messageType, value)
+	}
+
+	h.types[value] = messageType
+	return nil
+}
+
+// DecodeMessage decodes the message value with the message type named in its header.
+func (h *HeaderDecoder) DecodeMessage(message *sarama.ConsumerMessage) (string, error) {
+	value, ok := headerValue(message.Headers, h.header)
+	if !ok {
+		return h.fallback.DecodeMessage(message)
+	}
+
+	messageType, ok := h.types[value]
+	if !ok {
+		messageType = value
+	}
+
+	decoder, ok := h.decoders[messageType]
+	if !ok {
+		return "", fmt.Errorf("unknown message type %q in header %s of %s",
+			value, h.header, offsetMsg(message.Topic, message.Partition, message.Offset))
+	}
+
+	return decoder.Decode(message.Value)
+}
+
+// headerValue returns the value of the first header with the given key.
+func headerValue(headers []*sarama.RecordHeader, key string) (string, bool) {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,143 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/beatlabs/proton/v2/internal/protoparser"
+	"github.com/stretchr/testify/assert"
+)
+
+type namedDecoder string
+
+func (n namedDecoder) Decode(raw []byte) (string, error) {
+	return string(n) + ":" + string(raw), nil
+}
+
+func TestTopicDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback namedDecoder
+		topic    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "topic mapping",
+			topic:    "orders",
+			expected: "order:val",
+		},
+		{
+			name:     "topic mapping takes precedence over patterns",
+			topic:    "payments.v1",
+			expected: "payment-v1:val",
+		},
+		{
+			name:     "first matching pattern",
+			topic:    "payments.v2",
+			expected: "payment:val",
+		},
+		{
+			name:     "default decoder",
+			fallback: "default",
+			topic:    "users",
+			expected: "default:val",
+		},
+		{
+			name:  "no default decoder",
+			topic: "users",
+			err:   "no message type is mapped to topic users",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			decoder := NewTopicDecoder(nil)
+			if test.fallback != "" {
+				decoder = NewTopicDecoder(test.fallback)
+			}
+			assert.NoError(t, decoder.Add("^payments\\..*", namedDecoder("payment")))
+			assert.NoError(t, decoder.Add("^payments", namedDecoder("other")))
+			assert.NoError(t, decoder.Add("orders", namedDecoder("order")))
+			assert.NoError(t, decoder.Add("payments.v1", namedDecoder("payment-v1")))
+
+			// when
+			res, err := decoder.DecodeMessage(&sarama.ConsumerMessage{Topic: test.topic, Value: []byte("val")})
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestTopicDecoder_InvalidPattern(t *testing.T) {
+	err := NewTopicDecoder(nil).Add("^orders(", namedDecoder("order"))
+	assert.EqualError(t, err, "invalid topic pattern ^orders(: error parsing regexp: missing closing ): `^orders(`")
+}
+
+func TestHeaderDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  []*sarama.RecordHeader
+		expected string
+		err      string
+	}{
+		{
+			name:     "fully qualified message type",
+			headers:  []*sarama.RecordHeader{{Key: []byte("ce-type"), Value: []byte("shop.v1.Order")}},
+			expected: "order:val",
+		},
+		{
+			name:     "mapped header value",
+			headers:  []*sarama.RecordHeader{{Key: []byte("ce-type"), Value: []byte("payment.created")}},
+			expected: "payment:val",
+		},
+		{
+			name:     "no header uses the fallback decoder",
+			headers:  []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("shop.v1.Order")}},
+			expected: "default:val",
+		},
+		{
+			name:    "unknown message type",
+			headers: []*sarama.RecordHeader{{Key: []byte("ce-type"), Value: []byte("refund.created")}},
+			err:     `unknown message type "refund.created" in header ce-type of orders [3] at offset 42`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			decoder := NewHeaderDecoder("ce-type", map[string]protoparser.Decoder{
+				"shop.v1.Order":  namedDecoder("order"),
+				"pay.v1.Payment": namedDecoder("payment"),
+			}, NewTopicDecoder(namedDecoder("default")))
+			assert.NoError(t, decoder.Map("payment.created", "pay.v1.Payment"))
+
+			// when
+			res, err := decoder.DecodeMessage(&sarama.ConsumerMessage{
+				Topic:     "orders",
+				Partition: 3,
+				Offset:    42,
+				Headers:   test.headers,
+				Value:     []byte("val"),
+			})
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestHeaderDecoder_MapUnknownType(t *testing.T) {
+	err := NewHeaderDecoder("ce-type", map[string]protoparser.Decoder{}, nil).Map("order.created", "shop.v1.Order")
+	assert.EqualError(t, err, "unknown message type shop.v1.Order mapped to header value order.created")
+}
@@ -0,0 +1,249 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// groupHandler consumes the partitions claimed by this group member.
+// Partitions without a committed offset start from the configured start offset, the same as without a group.
+type groupHandler struct {
+	k *Kafka
+	// stop ends the group consumption once all claimed partitions reach their end
+	stop context.CancelFunc
+
+	offsets map[topicPartition]offsets
+
+	mu sync.Mutex
+	// positions are the next offsets to consume, so that partitions claimed again after a rebalance
+	// don't start over when offsets aren't committed.
+	positions map[topicPartition]int64
+	// remaining is the number of claimed partitions that haven't reached their end in the current session,
+	// the ones in pending, so that each of them is counted out once
+	remaining int
+	pending   map[topicPartition]bool
+}
+
+func newGroupHandler(k *Kafka, stop context.CancelFunc) *groupHandler {
+	oo := map[topicPartition]offsets{}
+	for _, o := range k.offsets {
+		oo[topicPartition{topic: o.topic, partition: o.partition}] = o
+	}
+
+	return &groupHandler{
+		k:         k,
+		stop:      stop,
+		offsets:   oo,
+		positions: map[topicPartition]int64{},
+	}
+}
+
+// consumeGroup consumes as a member of the consumer group until the context is cancelled,
+// or until all the claimed partitions reach their end.
+func (k *Kafka) consumeGroup(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	group, err := sarama.NewConsumerGroupFromClient(k.group, k.client)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = group.Close()
+	}()
+
+	go func() {
+		for err := range group.Errors() {
+			k.printer.PrintErr(err)
+		}
+	}()
+
+	handler := newGroupHandler(k, cancel)
+	for {
+		if err := group.Consume(ctx, k.topics, handler); err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Setup moves the partitions that have neither a committed offset nor a position in this run to their start offset.
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	committed, err := h.k.committedOffsets(session.Claims())
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remaining = 0
+	h.pending = map[topicPartition]bool{}
+	claimed := 0
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			claimed++
+			tp := topicPartition{topic: topic, partition: partition}
+			o, ok := h.offsets[tp]
+			if !ok {
+				o = h.newPartitionOffsets(topic, partition)
+				h.offsets[tp] = o
+			}
+
+			start, consumed := h.positions[tp]
+			if offset, ok := committed[tp]; ok && (h.k.commit || !consumed) {
+				h.k.log(fmt.Sprintf("# Resuming from committed %s", offsetMsg(topic, partition, offset)))
+				start = offset
+			} else {
+				if !consumed {
+					start, err = h.k.absoluteOffset(topic, partition, o.start)
+					if err != nil {
+						return err
+					}
+				}
+
+				h.k.log(fmt.Sprintf("# Going to consume from %s until %s", offsetMsg(topic, partition, start), offsetMsg(topic, partition, o.end)))
+				session.MarkOffset(topic, partition, start, "")
+			}
+
+			if o.end < 0 || start < o.end {
+				h.remaining++
+				h.pending[tp] = true
+			}
+		}
+	}
+
+	// a member without partitions, e.g. with more members than partitions, waits for a rebalance unless it exits at the end
+	if h.remaining == 0 && (claimed > 0 || h.k.exitAtEnd) {
+		h.k.log("# All claimed partitions are at their end: exiting")
+		h.stop()
+	}
+
+	return nil
+}
+
+// newPartitionOffsets resolves the offsets of a partition added to a topic since the consumer started.
+// Topic patterns aren't resolved again, so topics created since are never claimed.
+// If they can't be resolved, the partition is consumed from its beginning without an end.
+func (h *groupHandler) newPartitionOffsets(topic string, partition int32) offsets {
+	o, err := h.k.partitionOffsets(topic, partition)
+	if err != nil {
+		h.k.printer.PrintErr(err)
+		return offsets{topic: topic, partition: partition, start: sarama.OffsetOldest, end: sarama.OffsetNewest}
+	}
+	return o
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim processes the messages of a claimed partition until the session ends.
+// Messages from the end offset on are skipped rather than ending the claim, because that would end the whole session.
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tp := topicPartition{topic: claim.Topic(), partition: claim.Partition()}
+	h.mu.Lock()
+	end := h.offsets[tp].end
+	h.mu.Unlock()
+
+	for message := range claim.Messages() {
+		// the end offset can be skipped by compaction, so the first message past it ends the partition too
+		if end >= 0 && message.Offset >= end {
+			h.reachEnd(tp, end)
+			continue
+		}
+
+		h.k.processMessage(message)
+
+		if h.k.commit {
+			session.MarkMessage(message, "")
+		}
+
+		h.mu.Lock()
+		h.positions[tp] = message.Offset + 1
+		h.mu.Unlock()
+
+		if end >= 0 && message.Offset+1 >= end {
+			h.reachEnd(tp, end)
+		}
+	}
+
+	return nil
+}
+
+// reachEnd counts a claimed partition out once it reaches its end, and stops once all of them did.
+func (h *groupHandler) reachEnd(tp topicPartition, end int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.pending[tp] {
+		return
+	}
+	delete(h.pending, tp)
+
+	h.k.log(fmt.Sprintf("# Reached end of %s", offsetMsg(tp.topic, tp.partition, end)))
+	h.remaining--
+	if h.remaining == 0 {
+		h.k.log("# All claimed partitions reached their end: exiting")
+		h.stop()
+	}
+}
+
+// committedOffsets fetches the offsets committed by the consumer group for the given partitions.
+// Partitions without a committed offset are left out.
+func (k *Kafka) committedOffsets(claims map[string][]int32) (map[topicPartition]int64, error) {
+	coordinator, err := k.client.Coordinator(k.group)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &sarama.OffsetFetchRequest{ConsumerGroup: k.group, Version: 1}
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			req.AddPartition(topic, partition)
+		}
+	}
+
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[topicPartition]int64{}
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			block := resp.GetBlock(topic, partition)
+			if block == nil {
+				return nil, fmt.Errorf("no committed offset returned for %s [%d]", topic, partition)
+			}
+			if block.Err != sarama.ErrNoError {
+				return nil, block.Err
+			}
+			if block.Offset >= 0 {
+				res[topicPartition{topic: topic, partition: partition}] = block.Offset
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// absoluteOffset resolves the oldest and newest offset markers to the actual offsets of the partition.
+func (k *Kafka) absoluteOffset(topic string, partition int32, offset int64) (int64, error) {
+	if offset >= 0 {
+		return offset, nil
+	}
+	return k.client.GetOffset(topic, partition, offset)
+}
@@ -0,0 +1,229 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafka_ConsumeGroup(t *testing.T) {
+	const startTimestamp = 1646218065015
+
+	tests := []struct {
+		name      string
+		committed int64
+		commit    bool
+		// newPartition claims a partition added since the consumer started
+		newPartition bool
+		expected     []string
+	}{
+		{
+			name:      "first start uses the start offset",
+			committed: -1,
+			expected:  []string{"bar"},
+		},
+		{
+			name:      "committed offset takes precedence over the start offset",
+			committed: 0,
+			expected:  []string{"foo", "bar"},
+		},
+		{
+			name:      "committing consumed offsets",
+			committed: -1,
+			commit:    true,
+			expected:  []string{"bar"},
+		},
+		{
+			name:         "partition created after the start resolves its start offset",
+			committed:    -1,
+			newPartition: true,
+			expected:     []string{"bar"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			broker := newMockCluster(t, func(broker *sarama.MockBroker) map[string]sarama.MockResponse {
+				return map[string]sarama.MockResponse{
+					"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+						SetCoordinator(sarama.CoordinatorGroup, "my-group", broker),
+					"HeartbeatRequest":  sarama.NewMockHeartbeatResponse(t),
+					"JoinGroupRequest":  sarama.NewMockJoinGroupResponse(t).SetGroupProtocol(sarama.RangeBalanceStrategyName),
+					"LeaveGroupRequest": sarama.NewMockLeaveGroupResponse(t),
+					"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+						Topics: map[string][]int32{"my-topic": {0}},
+					}),
+					"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+						SetOffset("my-group", "my-topic", 0, test.committed, "", sarama.ErrNoError).
+						SetError(sarama.ErrNoError),
+					"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+				}
+			})
+			defer broker.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			printer := &collectingPrinter{}
+			k, err := NewKafka(ctx, Cfg{
+				URL:     broker.Addr(),
+				Topics:  []string{"my-topic"},
+				Start:   Offset{Kind: OffsetTime, Value: startTimestamp},
+				End:     Offset{Kind: OffsetEnd},
+				KeyGrep: ".*",
+				Group:   "my-group",
+				Commit:  test.commit,
+			}, valueDecoder{}, nil, printer)
+			require.NoError(t, err)
+			if test.newPartition {
+				k.offsets = nil
+			}
+
+			// when
+			errCh := k.Run()
+			assert.Eventually(t, func() bool {
+				return len(printer.values()) >= len(test.expected)
+			}, 5*time.Second, 10*time.Millisecond)
+			cancel()
+
+			// then
+			assert.NoError(t, <-errCh)
+			assert.Equal(t, test.expected, printer.values())
+			assert.Empty(t, printer.errs)
+
+			committed := false
+			for _, rr := range broker.History() {
+				if _, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+					committed = true
+				}
+			}
+			assert.Equal(t, test.commit, committed)
+		})
+	}
+}
+
+func TestKafka_ConsumeGroupWithoutPartitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		exitAtEnd bool
+		exits     bool
+	}{
+		{
+			name:  "waits for a rebalance",
+			exits: false,
+		},
+		{
+			name:      "exits at the end",
+			exitAtEnd: true,
+			exits:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			broker := newMockCluster(t, func(broker *sarama.MockBroker) map[string]sarama.MockResponse {
+				return map[string]sarama.MockResponse{
+					"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+						SetCoordinator(sarama.CoordinatorGroup, "my-group", broker),
+					"HeartbeatRequest":  sarama.NewMockHeartbeatResponse(t),
+					"JoinGroupRequest":  sarama.NewMockJoinGroupResponse(t).SetGroupProtocol(sarama.RangeBalanceStrategyName),
+					"LeaveGroupRequest": sarama.NewMockLeaveGroupResponse(t),
+					// more members than partitions: another member claimed the only partition
+					"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+						Topics: map[string][]int32{},
+					}),
+					"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).SetError(sarama.ErrNoError),
+				}
+			})
+			defer broker.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			printer := &collectingPrinter{}
+			k, err := NewKafka(ctx, Cfg{
+				URL:       broker.Addr(),
+				Topics:    []string{"my-topic"},
+				End:       Offset{Kind: OffsetEnd},
+				KeyGrep:   ".*",
+				Group:     "my-group",
+				ExitAtEnd: test.exitAtEnd,
+			}, valueDecoder{}, nil, printer)
+			require.NoError(t, err)
+
+			// when
+			errCh := k.Run()
+
+			// then
+			var exited bool
+			select {
+			case err := <-errCh:
+				assert.NoError(t, err)
+				exited = true
+			case <-time.After(500 * time.Millisecond):
+				cancel()
+				assert.NoError(t, <-errCh)
+			}
+			assert.Equal(t, test.exits, exited)
+			assert.Empty(t, printer.values())
+			assert.Empty(t, printer.errs)
+		})
+	}
+}
+
+func TestKafka_ConsumeGroupUntilEndWithCompactedOffset(t *testing.T) {
+	// given
+	broker := newMockCluster(t, func(broker *sarama.MockBroker) map[string]sarama.MockResponse {
+		return map[string]sarama.MockResponse{
+			"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+				SetCoordinator(sarama.CoordinatorGroup, "my-group", broker),
+			"HeartbeatRequest":  sarama.NewMockHeartbeatResponse(t),
+			"JoinGroupRequest":  sarama.NewMockJoinGroupResponse(t).SetGroupProtocol(sarama.RangeBalanceStrategyName),
+			"LeaveGroupRequest": sarama.NewMockLeaveGroupResponse(t),
+			"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{"my-topic": {0}},
+			}),
+			"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+				SetOffset("my-group", "my-topic", 0, -1, "", sarama.ErrNoError).
+				SetError(sarama.ErrNoError),
+			// the end is 2 when the consumer starts
+			"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+				SetOffset("my-topic", 0, sarama.OffsetOldest, 0).
+				SetOffset("my-topic", 0, sarama.OffsetNewest, 2),
+			// offset 1, right before the end, was compacted, and a message was written past the end since
+			"FetchRequest": sarama.NewMockFetchResponse(t, 1).SetVersion(4).
+				SetMessage("my-topic", 0, 0, sarama.StringEncoder("foo")).
+				SetMessage("my-topic", 0, 2, sarama.StringEncoder("baz")).
+				SetHighWaterMark("my-topic", 0, 3),
+		}
+	})
+	defer broker.Close()
+
+	printer := &collectingPrinter{}
+	k, err := NewKafka(context.Background(), Cfg{
+		URL:       broker.Addr(),
+		Topics:    []string{"my-topic"},
+		End:       Offset{Kind: OffsetEnd},
+		KeyGrep:   ".*",
+		Group:     "my-group",
+		ExitAtEnd: true,
+	}, valueDecoder{}, nil, printer)
+	require.NoError(t, err)
+
+	// when
+	errCh := k.Run()
+
+	// then
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer didn't stop at the end")
+	}
+	assert.Equal(t, []string{"foo"}, printer.values())
+	assert.Empty(t, printer.errs)
+}
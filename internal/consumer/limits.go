@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// limits stops the consumption of all partitions after a number of messages,
+// or once no message is received for a while.
+type limits struct {
+	maxMessages int
+	idleTimeout time.Duration
+
+	// stop cancels all the consumers, it's set when the consumer runs
+	stop context.CancelFunc
+	// received wakes the idle watch up whenever a message is received
+	received chan struct{}
+
+	mu    sync.Mutex
+	count int
+}
+
+func newLimits(maxMessages int, idleTimeout time.Duration) *limits {
+	return &limits{
+		maxMessages: maxMessages,
+		idleTimeout: idleTimeout,
+		received:    make(chan struct{}, 1),
+	}
+}
+
+// receive records that a message was received, whether it's printed or not.
+func (l *limits) receive() {
+	select {
+	case l.received <- struct{}{}:
+	default:
+	}
+}
+
+// take counts a message that is about to be printed. It returns false if the message count limit was already reached,
+// and stops the consumers once the limit is reached.
+func (l *limits) take() bool {
+	if l.maxMessages <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count >= l.maxMessages {
+		return false
+	}
+
+	l.count++
+	if l.count == l.maxMessages {
+		l.stop()
+	}
+	return true
+}
+
+// watchIdle stops the consumers if no message is received within the idle timeout, until the context is done.
+func (k *Kafka) watchIdle(ctx context.Context) {
+	if k.limits.idleTimeout <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(k.limits.idleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-k.limits.received:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(k.limits.idleTimeout)
+		case <-timer.C:
+			k.log(fmt.Sprintf("# No messages received for %s: exiting", k.limits.idleTimeout))
+			k.limits.stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package consumer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// OffsetKind tells how an Offset points to a position in a partition.
+type OffsetKind int
+
+const (
+	// OffsetBeginning is the oldest message of the partition.
+	OffsetBeginning OffsetKind = iota
+	// OffsetEnd is the end of the partition, where new messages get written.
+	OffsetEnd
+	// OffsetAbsolute is the message with the offset in Value.
+	OffsetAbsolute
+	// OffsetRelative is Value messages before the end of the partition.
+	OffsetRelative
+	// OffsetTime is the first message with a timestamp not before Value, in milliseconds since epoch.
+	OffsetTime
+)
+
+// Offset is a position in a partition to start or to stop consuming at.
+// The zero value is the beginning of the partition.
+type Offset struct {
+	Kind  OffsetKind
+	Value int64
+}
+
+// String describes the offset for logging.
+func (o Offset) String() string {
+	switch o.Kind {
+	case OffsetBeginning:
+		return "beginning"
+	case OffsetEnd:
+		return "end"
+	case OffsetAbsolute:
+		return fmt.Sprintf("offset %d", o.Value)
+	case OffsetRelative:
+		return fmt.Sprintf("%d messages before the end", o.Value)
+	default:
+		return fmt.Sprintf("time %s", time.UnixMilli(o.Value).UTC().Format(time.RFC3339))
+	}
+}
+
+// partitionOffset returns the offset of the partition the given offset points to.
+// The beginning and the end of the partition are returned as sarama.OffsetOldest and sarama.OffsetNewest.
+func partitionOffset(client sarama.Client, topic string, partition int32, o Offset) (int64, error) {
+	switch o.Kind {
+	case OffsetBeginning:
+		return sarama.OffsetOldest, nil
+	case OffsetEnd:
+		return sarama.OffsetNewest, nil
+	case OffsetAbsolute:
+		return o.Value, nil
+	case OffsetRelative:
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return 0, err
+		}
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return 0, err
+		}
+		if newest-o.Value < oldest {
+			return oldest, nil
+		}
+		return newest - o.Value, nil
+	case OffsetTime:
+		// Kafka answers with -1, i.e. sarama.OffsetNewest, if no message is that recent.
+		return client.GetOffset(topic, partition, o.Value)
+	default:
+		return 0, fmt.Errorf("unknown offset kind %d", o.Kind)
+	}
+}
@@ -0,0 +1,58 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionOffset(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("my-topic", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("my-topic", 0, sarama.OffsetOldest, 100).
+			SetOffset("my-topic", 0, sarama.OffsetNewest, 500).
+			SetOffset("my-topic", 0, 1646218065015, 321),
+	})
+
+	_, config, err := newConfig(Cfg{URL: broker.Addr()})
+	require.NoError(t, err)
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	defer client.Close()
+
+	tests := []struct {
+		name     string
+		offset   Offset
+		expected int64
+	}{
+		{name: "beginning", offset: Offset{Kind: OffsetBeginning}, expected: sarama.OffsetOldest},
+		{name: "end", offset: Offset{Kind: OffsetEnd}, expected: sarama.OffsetNewest},
+		{name: "absolute", offset: Offset{Kind: OffsetAbsolute, Value: 1500}, expected: 1500},
+		{name: "relative", offset: Offset{Kind: OffsetRelative, Value: 100}, expected: 400},
+		{name: "relative before the oldest message", offset: Offset{Kind: OffsetRelative, Value: 1000}, expected: 100},
+		{name: "time", offset: Offset{Kind: OffsetTime, Value: 1646218065015}, expected: 321},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := partitionOffset(client, "my-topic", 0, test.offset)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestOffset_String(t *testing.T) {
+	assert.Equal(t, "beginning", Offset{}.String())
+	assert.Equal(t, "end", Offset{Kind: OffsetEnd}.String())
+	assert.Equal(t, "offset 42", Offset{Kind: OffsetAbsolute, Value: 42}.String())
+	assert.Equal(t, "100 messages before the end", Offset{Kind: OffsetRelative, Value: 100}.String())
+	assert.Equal(t, "time 2022-03-02T10:47:45Z", Offset{Kind: OffsetTime, Value: 1646218065015}.String())
+}
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASLCfg is the SASL authentication configuration of this consumer.
+// Mechanism overrides the one given in the broker URI, TokenFile is only used by OAUTHBEARER.
+type SASLCfg struct {
+	Mechanism      string
+	User, Password string
+	TokenFile      string
+}
+
+// applySASL sets the SASL authentication up in the sarama config, if a SASL mechanism is given in any way.
+func applySASL(cfg SASLCfg, config *sarama.Config) error {
+	if cfg.Mechanism != "" {
+		mechanism, err := saslMechanism(cfg.Mechanism)
+		if err != nil {
+			return err
+		}
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = mechanism
+	}
+
+	if !config.Net.SASL.Enable {
+		return nil
+	}
+
+	// SASL is authenticated with SaslAuthenticate requests, which brokers support since Kafka 1.0.
+	config.Version = sarama.V1_0_0_0
+	config.Net.SASL.Version = sarama.SASLHandshakeV1
+
+	switch config.Net.SASL.Mechanism {
+	case sarama.SASLTypeOAuth:
+		if cfg.TokenFile == "" {
+			return fmt.Errorf("SASL mechanism %s requires a token file", config.Net.SASL.Mechanism)
+		}
+		config.Net.SASL.TokenProvider = tokenFile(cfg.TokenFile)
+		return nil
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: sha256.New}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: sha512.New}
+		}
+	}
+
+	if cfg.User == "" || cfg.Password == "" {
+		return fmt.Errorf("SASL mechanism %s requires a user and a password", config.Net.SASL.Mechanism)
+	}
+	config.Net.SASL.User = cfg.User
+	config.Net.SASL.Password = cfg.Password
+
+	return nil
+}
+
+// tokenFile provides OAUTHBEARER tokens by reading them from a file every time, so that refreshed tokens are picked up.
+type tokenFile string
+
+// Token reads the access token from the file.
+func (f tokenFile) Token() (*sarama.AccessToken, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the SASL token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return nil, fmt.Errorf("SASL token file %s is empty", f)
+	}
+
+	return &sarama.AccessToken{Token: token}, nil
+}
+
+// scramClient is the SCRAM-SHA-256/512 conversation with a broker.
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+// Begin prepares the conversation with the credentials.
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+
+	s.conversation = client.NewConversation()
+	return nil
+}
+
+// Step answers to a challenge of the broker.
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.conversation.Step(challenge)
+}
+
+// Done returns whether the conversation is over.
+func (s *scramClient) Done() bool {
+	return s.conversation.Done()
+}
+
+// saslMechanism returns the sarama SASL mechanism for its name, e.g. scram-sha-512.
+func saslMechanism(name string) (sarama.SASLMechanism, error) {
+	switch strings.ToLower(name) {
+	case "plain":
+		return sarama.SASLTypePlaintext, nil
+	case "scram-sha-256":
+		return sarama.SASLTypeSCRAMSHA256, nil
+	case "scram-sha-512":
+		return sarama.SASLTypeSCRAMSHA512, nil
+	case "oauthbearer":
+		return sarama.SASLTypeOAuth, nil
+	default:
+		return "", fmt.Errorf("unknown SASL mechanism %q, expected one of plain, scram-sha-256, scram-sha-512, oauthbearer", name)
+	}
+}
@@ -0,0 +1,191 @@
+package consumer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySASL(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		sasl   SASLCfg
+		assert func(*testing.T, *sarama.Config)
+		err    string
+	}{
+		{
+			name: "no SASL",
+			url:  "host1",
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.False(t, config.Net.SASL.Enable)
+				assert.Equal(t, sarama.V0_11_0_0, config.Version)
+			},
+		},
+		{
+			name: "plain",
+			url:  "host1",
+			sasl: SASLCfg{Mechanism: "plain", User: "user", Password: "pass"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.True(t, config.Net.SASL.Enable)
+				assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), config.Net.SASL.Mechanism)
+				assert.Equal(t, "user", config.Net.SASL.User)
+				assert.Equal(t, "pass", config.Net.SASL.Password)
+				assert.Equal(t, sarama.V1_0_0_0, config.Version)
+				assert.Equal(t, sarama.SASLHandshakeV1, config.Net.SASL.Version)
+			},
+		},
+		{
+			name: "mechanism overrides the URI's",
+			url:  "kafka://host1/?sasl=plain",
+			sasl: SASLCfg{Mechanism: "scram-sha-256", User: "user", Password: "pass"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA256), config.Net.SASL.Mechanism)
+				assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc)
+				assert.NoError(t, config.Validate())
+			},
+		},
+		{
+			name: "scram-sha-512",
+			url:  "kafka://host1/?sasl=scram-sha-512",
+			sasl: SASLCfg{User: "user", Password: "pass"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+				assert.NoError(t, config.Validate())
+			},
+		},
+		{
+			name: "oauthbearer",
+			url:  "host1",
+			sasl: SASLCfg{Mechanism: "oauthbearer", TokenFile: "token"},
+			assert: func(t *testing.T, config *sarama.Config) {
+				assert.Equal(t, tokenFile("token"), config.Net.SASL.TokenProvider)
+				assert.NoError(t, config.Validate())
+			},
+		},
+		{
+			name: "oauthbearer without token file",
+			url:  "kafka://host1/?sasl=oauthbearer",
+			err:  "SASL mechanism OAUTHBEARER requires a token file",
+		},
+		{
+			name: "missing password",
+			url:  "host1",
+			sasl: SASLCfg{Mechanism: "scram-sha-512", User: "user"},
+			err:  "SASL mechanism SCRAM-SHA-512 requires a user and a password",
+		},
+		{
+			name: "unknown mechanism",
+			url:  "host1",
+			sasl: SASLCfg{Mechanism: "gssapi"},
+			err:  `unknown SASL mechanism "gssapi", expected one of plain, scram-sha-256, scram-sha-512, oauthbearer`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			_, config, err := newConfig(Cfg{URL: test.url, SASL: test.sasl})
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			test.assert(t, config)
+		})
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+
+	_, err := tokenFile(path).Token()
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("\n"), 0600))
+	_, err = tokenFile(path).Token()
+	assert.EqualError(t, err, "SASL token file "+path+" is empty")
+
+	assert.NoError(t, os.WriteFile(path, []byte("my-token\n"), 0600))
+	token, err := tokenFile(path).Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "my-token", token.Token)
+}
+
+func TestNewKafka_SASL(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenPath, []byte("my-token"), 0600))
+
+	tests := []struct {
+		name      string
+		sasl      SASLCfg
+		handshake *sarama.MockSaslHandshakeResponse
+		expectErr bool
+	}{
+		{
+			name:      "plain",
+			sasl:      SASLCfg{Mechanism: "plain", User: "user", Password: "pass"},
+			handshake: sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypePlaintext}),
+		},
+		{
+			name:      "oauthbearer",
+			sasl:      SASLCfg{Mechanism: "oauthbearer", TokenFile: tokenPath},
+			handshake: sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypeOAuth}),
+		},
+		{
+			name: "mechanism not enabled on the broker",
+			sasl: SASLCfg{Mechanism: "plain", User: "user", Password: "pass"},
+			handshake: sarama.NewMockSaslHandshakeResponse(t).
+				SetEnabledMechanisms([]string{sarama.SASLTypeSCRAMSHA512}).
+				SetError(sarama.ErrUnsupportedSASLMechanism),
+			expectErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			broker := sarama.NewMockBroker(t, 1)
+			defer broker.Close()
+
+			broker.SetHandlerByMap(map[string]sarama.MockResponse{
+				"SaslHandshakeRequest":    test.handshake,
+				"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t),
+				"MetadataRequest": sarama.NewMockMetadataResponse(t).
+					SetBroker(broker.Addr(), broker.BrokerID()).
+					SetLeader("my-topic", 0, broker.BrokerID()),
+			})
+
+			// when
+			k, err := NewKafka(context.Background(), Cfg{
+				URL:     broker.Addr(),
+				Topics:  []string{"my-topic"},
+				End:     Offset{Kind: OffsetEnd},
+				KeyGrep: ".*",
+				SASL:    test.sasl,
+			}, nil, nil, nil)
+
+			// then
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, k.offsets, 1)
+			assert.NoError(t, k.client.Close())
+
+			authenticated := false
+			for _, rr := range broker.History() {
+				if _, ok := rr.Request.(*sarama.SaslAuthenticateRequest); ok {
+					authenticated = true
+				}
+			}
+			assert.True(t, authenticated)
+		})
+	}
+}
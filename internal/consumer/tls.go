@@ -0,0 +1,140 @@
+package consumer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// tlsDiagnoseTimeout bounds each step of diagnosing why the connection to a broker failed.
+const tlsDiagnoseTimeout = 5 * time.Second
+
+// TLSCfg is the TLS configuration of this consumer.
+// Setting any of its fields enables TLS, as does the tls parameter of the broker URI.
+type TLSCfg struct {
+	Enable             bool
+	CAFile             string
+	CertFile, KeyFile  string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (c TLSCfg) enabled() bool {
+	return c.Enable || c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "" || c.InsecureSkipVerify
+}
+
+// applyTLS sets TLS up in the sarama config, loading the CA bundle and the client certificate if given.
+func applyTLS(cfg TLSCfg, config *sarama.Config) error {
+	if cfg.enabled() {
+		config.Net.TLS.Enable = true
+	}
+
+	if !config.Net.TLS.Enable {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("loading the TLS CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("loading the TLS CA file: no PEM certificates found in %s", cfg.CAFile)
+		}
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return errors.New("loading the TLS client certificate: both a certificate and a key file are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading the TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	config.Net.TLS.Config = tlsConfig
+	return nil
+}
+
+// diagnoseTLS connects to the brokers again outside sarama, to tell which step of the TLS connection fails.
+// It returns nil if no TLS step fails for any broker, in which case the original error is the relevant one.
+func diagnoseTLS(brokers []string, tlsConfig *tls.Config) error {
+	var err error
+	for _, broker := range brokers {
+		if err = diagnoseBrokerTLS(broker, tlsConfig); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func diagnoseBrokerTLS(broker string, tlsConfig *tls.Config) error {
+	conn, err := net.DialTimeout("tcp", broker, tlsDiagnoseTimeout)
+	if err != nil {
+		return fmt.Errorf("connecting to broker %s: %w", broker, err)
+	}
+	defer conn.Close()
+
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(broker)
+		if err != nil {
+			return err
+		}
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	_ = tlsConn.SetDeadline(time.Now().Add(tlsDiagnoseTimeout))
+
+	if err := tlsConn.Handshake(); err != nil {
+		var (
+			unknownAuthority x509.UnknownAuthorityError
+			hostname         x509.HostnameError
+			invalid          x509.CertificateInvalidError
+			recordHeader     tls.RecordHeaderError
+		)
+		switch {
+		case errors.As(err, &unknownAuthority):
+			return fmt.Errorf("verifying the certificate of broker %s: it isn't signed by a trusted CA, check the CA file: %w", broker, err)
+		case errors.As(err, &hostname):
+			return fmt.Errorf("verifying the certificate of broker %s: it isn't valid for the host name, check the server name: %w", broker, err)
+		case errors.As(err, &invalid):
+			return fmt.Errorf("verifying the certificate of broker %s: %w", broker, err)
+		case errors.As(err, &recordHeader):
+			return fmt.Errorf("TLS handshake with broker %s: the broker doesn't seem to accept TLS connections: %w", broker, err)
+		default:
+			return fmt.Errorf("TLS handshake with broker %s: %w", broker, err)
+		}
+	}
+
+	// With TLS 1.3 the broker verifies the client certificate after the client considers the handshake done,
+	// so a rejected certificate only shows up as an alert on the first read.
+	_ = tlsConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := tlsConn.Read(make([]byte, 1)); err != nil {
+		var netErr net.Error
+		if !errors.Is(err, io.EOF) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			return fmt.Errorf("TLS client certificate verification by broker %s: %w", broker, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package consumer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by the parent, or a self-signed CA if there's no parent, and writes it to dir.
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+	require.NoError(t, os.WriteFile(tc.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(tc.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return tc
+}
+
+func TestApplyTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	client := newTestCert(t, dir, "client", ca)
+
+	notPEM := filepath.Join(dir, "not-pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+
+	tests := []struct {
+		name   string
+		url    string
+		tls    TLSCfg
+		assert func(*testing.T, *tls.Config)
+		err    string
+	}{
+		{
+			name: "no TLS",
+			url:  "host1",
+			assert: func(t *testing.T, config *tls.Config) {
+				assert.Nil(t, config)
+			},
+		},
+		{
+			name: "enabled by the broker URI",
+			url:  "kafka://host1/?tls=true",
+			assert: func(t *testing.T, config *tls.Config) {
+				assert.NotNil(t, config)
+				assert.Nil(t, config.RootCAs)
+				assert.Empty(t, config.Certificates)
+			},
+		},
+		{
+			name: "mTLS",
+			url:  "host1",
+			tls:  TLSCfg{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile, ServerName: "kafka"},
+			assert: func(t *testing.T, config *tls.Config) {
+				assert.NotNil(t, config.RootCAs)
+				assert.Len(t, config.Certificates, 1)
+				assert.Equal(t, "kafka", config.ServerName)
+				assert.False(t, config.InsecureSkipVerify)
+			},
+		},
+		{
+			name: "insecure",
+			url:  "host1",
+			tls:  TLSCfg{InsecureSkipVerify: true},
+			assert: func(t *testing.T, config *tls.Config) {
+				assert.True(t, config.InsecureSkipVerify)
+			},
+		},
+		{
+			name: "missing CA file",
+			url:  "host1",
+			tls:  TLSCfg{CAFile: filepath.Join(dir, "missing")},
+			err:  "loading the TLS CA file: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+		},
+		{
+			name: "CA file without certificates",
+			url:  "host1",
+			tls:  TLSCfg{CAFile: notPEM},
+			err:  "loading the TLS CA file: no PEM certificates found in " + notPEM,
+		},
+		{
+			name: "certificate without key",
+			url:  "host1",
+			tls:  TLSCfg{CertFile: client.certFile},
+			err:  "loading the TLS client certificate: both a certificate and a key file are required",
+		},
+		{
+			name: "mismatching key",
+			url:  "host1",
+			tls:  TLSCfg{CertFile: client.certFile, KeyFile: ca.keyFile},
+			err:  "loading the TLS client certificate: tls: private key does not match public key",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			_, config, err := newConfig(Cfg{URL: test.url, TLS: test.tls})
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			test.assert(t, config.Net.TLS.Config)
+		})
+	}
+}
+
+// listen serves connections with the given handler until the test ends and returns the listening address.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestDiagnoseTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca)
+	client := newTestCert(t, dir, "client", ca)
+	otherCA := newTestCert(t, dir, "other-ca", nil)
+
+	serverCert, err := tls.LoadX509KeyPair(server.certFile, server.keyFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	tlsBroker := listen(t, func(conn net.Conn) {
+		tlsConn := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		})
+		if tlsConn.Handshake() == nil {
+			// keep the connection open like a broker waiting for requests
+			_, _ = tlsConn.Read(make([]byte, 1))
+		}
+	})
+	plainBroker := listen(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("definitely not TLS"))
+	})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedBroker := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	tests := []struct {
+		name    string
+		brokers []string
+		tls     TLSCfg
+		err     string
+	}{
+		{
+			name:    "successful mTLS connection",
+			brokers: []string{tlsBroker},
+			tls:     TLSCfg{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+		},
+		{
+			name:    "one successful broker is enough",
+			brokers: []string{closedBroker, tlsBroker},
+			tls:     TLSCfg{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+		},
+		{
+			name:    "broker is down",
+			brokers: []string{closedBroker},
+			tls:     TLSCfg{Enable: true},
+			err:     "connecting to broker " + closedBroker,
+		},
+		{
+			name:    "untrusted broker certificate",
+			brokers: []string{tlsBroker},
+			tls:     TLSCfg{CAFile: otherCA.certFile, CertFile: client.certFile, KeyFile: client.keyFile},
+			err:     "verifying the certificate of broker " + tlsBroker + ": it isn't signed by a trusted CA",
+		},
+		{
+			name:    "wrong server name",
+			brokers: []string{tlsBroker},
+			tls:     TLSCfg{CAFile: ca.certFile, CertFile: client.certFile, KeyFile: client.keyFile, ServerName: "kafka"},
+			err:     "verifying the certificate of broker " + tlsBroker + ": it isn't valid for the host name",
+		},
+		{
+			name:    "missing client certificate",
+			brokers: []string{tlsBroker},
+			tls:     TLSCfg{CAFile: ca.certFile},
+			err:     "TLS client certificate verification by broker " + tlsBroker,
+		},
+		{
+			name:    "broker without TLS",
+			brokers: []string{plainBroker},
+			tls:     TLSCfg{Enable: true},
+			err:     "TLS handshake with broker " + plainBroker + ": the broker doesn't seem to accept TLS connections",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			_, config, err := newConfig(Cfg{URL: "host1", TLS: test.tls})
+			require.NoError(t, err)
+
+			// when
+			err = diagnoseTLS(test.brokers, config.Net.TLS.Config)
+
+			// then
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
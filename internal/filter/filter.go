@@ -0,0 +1,358 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// operators are the supported comparisons, the longer ones first so that they are matched before their prefixes.
+var operators = []string{"==", "!=", "=~", "<=", ">=", "<", ">"}
+
+// Expr is a jq-style filter over a json document, e.g. `.person.email == "x@y"`.
+// It's a path, optionally followed by a comparison operator and a json literal:
+//
+//	.person.email == "x@y"      equality, != for inequality
+//	.person.email =~ "@y$"      regular expression match
+//	.amount.units < 0           numeric comparison, also <=, >, >=
+//	.people[].name == "ABC"     any of the array's elements, or of the object's values
+//	.people[0]."e-mail"         index and quoted keys
+//	.person.active              truthiness: the value exists and is neither null nor false
+//
+// A missing key is null, the same as in jq.
+type Expr struct {
+	path       []step
+	op         string
+	literal    interface{}
+	pattern    *regexp.Regexp
+	ignoreCase bool
+}
+
+type stepKind int
+
+const (
+	keyStep stepKind = iota
+	indexStep
+	iterateStep
+)
+
+type step struct {
+	kind  stepKind
+	key   string
+	index int
+}
+
+// Parse parses a filter expression. With ignoreCase, strings are compared and matched case-insensitively.
+func Parse(expr string, ignoreCase bool) (*Expr, error) {
+	path, rest, err := parsePath(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+	}
+
+	e := &Expr{path: path, ignoreCase: ignoreCase}
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return e, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			e.op = op
+			break
+		}
+	}
+	if e.op == "" {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q, expected one of %s", expr, rest, strings.Join(operators, " "))
+	}
+
+	literal := strings.TrimSpace(rest[len(e.op):])
+	d := json.NewDecoder(strings.NewReader(literal))
+	d.UseNumber()
+	if err := d.Decode(&e.literal); err != nil || d.More() {
+		return nil, fmt.Errorf("invalid filter %q: %q isn't a json literal", expr, literal)
+	}
+
+	switch e.op {
+	case "=~":
+		s, ok := e.literal.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q: =~ expects a string", expr)
+		}
+		if ignoreCase {
+			s = "(?i)" + s
+		}
+		if e.pattern, err = regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+		}
+	case "<", "<=", ">", ">=":
+		switch e.literal.(type) {
+		case json.Number, string:
+		default:
+			return nil, fmt.Errorf("invalid filter %q: %s expects a number or a string", expr, e.op)
+		}
+	}
+
+	return e, nil
+}
+
+// MatchValue tells whether any of the values the path leads to in a decoded json document satisfies the expression.
+// Numbers of the document are expected to be decoded as json.Number, see json.Decoder.UseNumber.
+func (e *Expr) MatchValue(v interface{}) bool {
+	for _, value := range values(e.path, v) {
+		if e.matches(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Expr) matches(v interface{}) bool {
+	switch e.op {
+	case "":
+		return v != nil && v != false
+	case "==":
+		return e.equal(v)
+	case "!=":
+		return !e.equal(v)
+	case "=~":
+		s, ok := str(v)
+		return ok && e.pattern.MatchString(s)
+	default:
+		c, ok := e.compare(v)
+		if !ok {
+			return false
+		}
+		switch e.op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}
+}
+
+func (e *Expr) equal(v interface{}) bool {
+	switch literal := e.literal.(type) {
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		if e.ignoreCase {
+			return strings.EqualFold(s, literal)
+		}
+		return s == literal
+	case json.Number:
+		c, ok := e.compare(v)
+		return ok && c == 0
+	default:
+		return reflect.DeepEqual(v, e.literal)
+	}
+}
+
+// compare compares the value to the literal, numbers numerically and strings lexically.
+// Strings holding numbers, like 64-bit integers in protobuf json, are compared as numbers to number literals.
+func (e *Expr) compare(v interface{}) (int, bool) {
+	switch literal := e.literal.(type) {
+	case json.Number:
+		s, ok := str(v)
+		if !ok {
+			return 0, false
+		}
+		if s == literal.String() {
+			return 0, true
+		}
+		// integers are compared exactly, as 64-bit ones don't fit in the mantissa of a float
+		if a, ok := new(big.Int).SetString(s, 10); ok {
+			if b, ok := new(big.Int).SetString(literal.String(), 10); ok {
+				return a.Cmp(b), true
+			}
+		}
+		a, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, false
+		}
+		b, err := literal.Float64()
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		default:
+			return 0, true
+		}
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return 0, false
+		}
+		if e.ignoreCase {
+			return strings.Compare(strings.ToLower(s), strings.ToLower(literal)), true
+		}
+		return strings.Compare(s, literal), true
+	default:
+		return 0, false
+	}
+}
+
+// str returns strings and numbers as strings.
+func str(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// values returns all the values the path leads to.
+func values(path []step, v interface{}) []interface{} {
+	vv := []interface{}{v}
+	for _, s := range path {
+		var next []interface{}
+		for _, v := range vv {
+			switch s.kind {
+			case keyStep:
+				o, _ := v.(map[string]interface{})
+				next = append(next, o[s.key])
+			case indexStep:
+				a, _ := v.([]interface{})
+				i := s.index
+				if i < 0 {
+					i += len(a)
+				}
+				if i >= 0 && i < len(a) {
+					next = append(next, a[i])
+				} else {
+					next = append(next, nil)
+				}
+			case iterateStep:
+				switch v := v.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					for _, value := range v {
+						next = append(next, value)
+					}
+				}
+			}
+		}
+		vv = next
+	}
+	return vv
+}
+
+// parsePath parses the path at the start of the expression, and returns the rest of the expression.
+func parsePath(expr string) ([]step, string, error) {
+	if !strings.HasPrefix(expr, ".") {
+		return nil, "", fmt.Errorf("a path starting with \".\" is expected")
+	}
+
+	var path []step
+	i := 0
+	for i < len(expr) {
+		switch expr[i] {
+		case '.':
+			i++
+			switch {
+			case i == len(expr) || expr[i] == '[' || isSpace(expr[i]) || strings.IndexByte("=!<>", expr[i]) >= 0:
+				// "." alone is the whole document, ".[" is the same as "["
+				if len(path) > 0 && (i == len(expr) || expr[i] != '[') {
+					return nil, "", fmt.Errorf("a key is expected after \".\" at %d", i)
+				}
+			case expr[i] == '"':
+				key, n, err := parseQuoted(expr[i:])
+				if err != nil {
+					return nil, "", err
+				}
+				path = append(path, step{kind: keyStep, key: key})
+				i += n
+			default:
+				start := i
+				for i < len(expr) && isIdentifier(expr[i]) {
+					i++
+				}
+				if i == start {
+					return nil, "", fmt.Errorf("unexpected %q at %d", expr[i], i)
+				}
+				path = append(path, step{kind: keyStep, key: expr[start:i]})
+			}
+		case '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, "", fmt.Errorf("unclosed \"[\" at %d", i)
+			}
+			inner := strings.TrimSpace(expr[i+1 : i+end])
+
+			switch {
+			case inner == "":
+				path = append(path, step{kind: iterateStep})
+				i += end + 1
+			case inner[0] == '"':
+				key, n, err := parseQuoted(expr[i+1:])
+				if err != nil {
+					return nil, "", err
+				}
+				i += 1 + n
+				if i >= len(expr) || expr[i] != ']' {
+					return nil, "", fmt.Errorf("unclosed \"[\" at %d", i)
+				}
+				path = append(path, step{kind: keyStep, key: key})
+				i++
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, "", fmt.Errorf("invalid index %q", inner)
+				}
+				path = append(path, step{kind: indexStep, index: index})
+				i += end + 1
+			}
+		default:
+			if isSpace(expr[i]) || strings.IndexByte("=!<>", expr[i]) >= 0 {
+				return path, expr[i:], nil
+			}
+			return nil, "", fmt.Errorf("unexpected %q at %d", expr[i], i)
+		}
+	}
+
+	return path, "", nil
+}
+
+// parseQuoted parses the json string at the start of s, and returns it along with its length.
+func parseQuoted(s string) (string, int, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			var key string
+			if err := json.Unmarshal([]byte(s[:i+1]), &key); err != nil {
+				return "", 0, fmt.Errorf("invalid quoted key %s", s[:i+1])
+			}
+			return key, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unclosed quoted key %s", s)
+}
+
+func isIdentifier(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const document = `{
+	"person": {"name": "ABC", "email": "x@y", "id": "9007199254740993", "age": 42, "active": true, "deleted": false},
+	"people": [{"name": "ABC"}, {"name": "DEF", "e-mail": "d@e"}],
+	"amount": {"units": -5},
+	"note": null
+}`
+
+func TestExpr_MatchValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		ignoreCase bool
+		expected   bool
+	}{
+		{name: "string equality", expr: `.person.email == "x@y"`, expected: true},
+		{name: "string inequality", expr: `.person.email != "x@y"`, expected: false},
+		{name: "case-sensitive equality", expr: `.person.name == "abc"`, expected: false},
+		{name: "case-insensitive equality", expr: `.person.name == "abc"`, ignoreCase: true, expected: true},
+		{name: "no spaces", expr: `.person.email=="x@y"`, expected: true},
+		{name: "regexp", expr: `.person.email =~ "@y$"`, expected: true},
+		{name: "case-insensitive regexp", expr: `.person.name =~ "^a"`, ignoreCase: true, expected: true},
+		{name: "number", expr: `.person.age == 42`, expected: true},
+		{name: "number as string", expr: `.person.id == 9007199254740993`, expected: true},
+		{name: "64-bit number isn't rounded", expr: `.person.id == 9007199254740992`, expected: false},
+		{name: "64-bit number comparison", expr: `.person.id > 9007199254740992`, expected: true},
+		{name: "unsigned 64-bit number comparison", expr: `.person.id < 18446744073709551615`, expected: true},
+		{name: "fractional number comparison", expr: `.person.age < 42.5`, expected: true},
+		{name: "number isn't a string", expr: `.person.age == "42"`, expected: false},
+		{name: "less than", expr: `.amount.units < 0`, expected: true},
+		{name: "greater or equal", expr: `.person.age >= 43`, expected: false},
+		{name: "string comparison", expr: `.person.name > "AB"`, expected: true},
+		{name: "boolean", expr: `.person.active == true`, expected: true},
+		{name: "null", expr: `.note == null`, expected: true},
+		{name: "missing key is null", expr: `.person.phone == null`, expected: true},
+		{name: "missing key isn't equal", expr: `.person.phone != "123"`, expected: true},
+		{name: "index", expr: `.people[1].name == "DEF"`, expected: true},
+		{name: "negative index", expr: `.people[-1].name == "DEF"`, expected: true},
+		{name: "index out of range", expr: `.people[5].name == "DEF"`, expected: false},
+		{name: "any element", expr: `.people[].name == "DEF"`, expected: true},
+		{name: "no element", expr: `.people[].name == "GHI"`, expected: false},
+		{name: "any value of an object", expr: `.person[] == "x@y"`, expected: true},
+		{name: "quoted key", expr: `.people[1]."e-mail" == "d@e"`, expected: true},
+		{name: "bracket quoted key", expr: `.people[1]["e-mail"] == "d@e"`, expected: true},
+		{name: "truthy", expr: `.person.active`, expected: true},
+		{name: "false isn't truthy", expr: `.person.deleted`, expected: false},
+		{name: "missing isn't truthy", expr: `.person.phone`, expected: false},
+		{name: "whole document", expr: `.`, expected: true},
+		{name: "object equality", expr: `.amount == {"units": -5}`, expected: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			e, err := Parse(test.expr, test.ignoreCase)
+			require.NoError(t, err)
+
+			// when
+			res := e.MatchValue(decode(t, document))
+
+			// then
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		err  string
+	}{
+		{name: "no path", expr: `person == "x"`, err: `invalid filter "person == \"x\"": a path starting with "." is expected`},
+		{name: "missing key", expr: `.person. == "x"`, err: `invalid filter ".person. == \"x\"": a key is expected after "." at 8`},
+		{name: "unknown operator", expr: `.person ~ "x"`, err: `invalid filter ".person ~ \"x\"": unexpected "~ \"x\"", expected one of == != =~ <= >= < >`},
+		{name: "not a literal", expr: `.person == x`, err: `invalid filter ".person == x": "x" isn't a json literal`},
+		{name: "trailing literal", expr: `.person == "x" "y"`, err: `invalid filter ".person == \"x\" \"y\"": "\"x\" \"y\"" isn't a json literal`},
+		{name: "regexp of a number", expr: `.person =~ 1`, err: `invalid filter ".person =~ 1": =~ expects a string`},
+		{name: "invalid regexp", expr: `.person =~ "("`, err: "invalid filter \".person =~ \\\"(\\\"\": error parsing regexp: missing closing ): `(`"},
+		{name: "comparison with a boolean", expr: `.person < true`, err: `invalid filter ".person < true": < expects a number or a string`},
+		{name: "unclosed bracket", expr: `.people[0`, err: `invalid filter ".people[0": unclosed "[" at 7`},
+		{name: "invalid index", expr: `.people[a]`, err: `invalid filter ".people[a]": invalid index "a"`},
+		{name: "unclosed quote", expr: `."name`, err: `invalid filter ".\"name": unclosed quoted key "name`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.expr, false)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+// decode decodes a json document the way the consumer does before filtering it.
+func decode(t *testing.T, document string) interface{} {
+	t.Helper()
+
+	d := json.NewDecoder(strings.NewReader(document))
+	d.UseNumber()
+
+	var v interface{}
+	require.NoError(t, d.Decode(&v))
+	return v
+}
@@ -0,0 +1,137 @@
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// parseEmbeddedPath parses a path to a field of a json object, e.g. .event.payload.
+func parseEmbeddedPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, ".") || len(path) == 1 {
+		return nil, fmt.Errorf("invalid embedded path %q, expected keys starting with \".\", e.g. .payload", path)
+	}
+
+	keys := strings.Split(path[1:], ".")
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("invalid embedded path %q, a key is missing", path)
+		}
+	}
+	return keys, nil
+}
+
+// convertEmbedded converts the message embedded in a json line at the path, and replaces it in place.
+// The other fields are passed through unchanged, and so is a null message.
+func (c Converter) convertEmbedded(md *desc.MessageDescriptor, path []string, line []byte) ([]byte, error) {
+	encoding := c.Encoding
+	if !encoding.isText() {
+		encoding = EncodingBase64
+	}
+
+	res, found, err := replaceAt(bytes.TrimSpace(line), path, func(value stdjson.RawMessage) (stdjson.RawMessage, error) {
+		if string(value) == "null" {
+			return value, nil
+		}
+
+		var s string
+		if err := stdjson.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("the value at .%s isn't a string", strings.Join(path, "."))
+		}
+
+		rawMessage, err := encoding.decode([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		return c.embeddedJSON(md, rawMessage)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("there's no .%s in the line", strings.Join(path, "."))
+	}
+
+	return c.formatLine(res)
+}
+
+// embeddedJSON converts a single message to compact json, whatever the format, so that it can be embedded in a json line.
+func (c Converter) embeddedJSON(md *desc.MessageDescriptor, rawMessage []byte) ([]byte, error) {
+	c.Format, c.Indent = FormatJSON, false
+	return c.unmarshalProtoBytesToJSON(md, rawMessage)
+}
+
+// formatLine formats a json line in the format of the converter, which can't be textproto.
+func (c Converter) formatLine(line []byte) ([]byte, error) {
+	switch {
+	case c.Format == FormatYAML:
+		return jsonToYAML(line)
+	case c.Format == FormatJSONIndent || c.Indent:
+		var buf bytes.Buffer
+		if err := stdjson.Indent(&buf, line, "", "  "); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return line, nil
+	}
+}
+
+// replaceAt replaces the value at the path of a json object with the result of replace,
+// keeping the order of the fields and the other values as they are. It tells whether the path was found.
+func replaceAt(object []byte, path []string, replace func(stdjson.RawMessage) (stdjson.RawMessage, error)) ([]byte, bool, error) {
+	d := stdjson.NewDecoder(bytes.NewReader(object))
+	tok, err := d.Token()
+	if err != nil {
+		return nil, false, err
+	}
+	if tok != stdjson.Delim('{') {
+		return object, false, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	found := false
+	for i := 0; d.More(); i++ {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		key, _ := tok.(string)
+
+		var value stdjson.RawMessage
+		if err := d.Decode(&value); err != nil {
+			return nil, false, err
+		}
+
+		if key == path[0] && !found {
+			if len(path) == 1 {
+				found = true
+				value, err = replace(value)
+			} else {
+				value, found, err = replaceAt(value, path[1:], replace)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(&buf, key); err != nil {
+			return nil, false, err
+		}
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	if _, err := d.Token(); err != nil {
+		return nil, false, err
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), found, nil
+}
@@ -0,0 +1,70 @@
+package json
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Encoding is how messages are encoded in a stream.
+type Encoding string
+
+const (
+	// EncodingRaw is binary messages, delimited by the framing. The default.
+	EncodingRaw Encoding = "raw"
+	// EncodingBase64 is a base64 encoded message per line.
+	EncodingBase64 Encoding = "base64"
+	// EncodingBase64URL is a URL-safe base64 encoded message per line.
+	EncodingBase64URL Encoding = "base64url"
+	// EncodingHex is a hex encoded message per line.
+	EncodingHex Encoding = "hex"
+)
+
+// Encodings are all the supported encodings.
+var Encodings = []Encoding{EncodingRaw, EncodingBase64, EncodingBase64URL, EncodingHex}
+
+// ParseEncoding returns the encoding with the given name.
+func ParseEncoding(name string) (Encoding, error) {
+	i, err := lookup("encoding", name, len(Encodings), func(i int) string { return string(Encodings[i]) })
+	if err != nil {
+		return "", err
+	}
+	return Encodings[i], nil
+}
+
+// isText tells whether messages are encoded as text, one per line.
+func (e Encoding) isText() bool {
+	return e != "" && e != EncodingRaw
+}
+
+// decode decodes a line of a text encoding. Surrounding whitespace and base64 padding are optional.
+func (e Encoding) decode(line []byte) ([]byte, error) {
+	line = bytes.TrimSpace(line)
+
+	switch e {
+	case EncodingBase64:
+		return decodeBase64(base64.RawStdEncoding, line)
+	case EncodingBase64URL:
+		return decodeBase64(base64.RawURLEncoding, line)
+	case EncodingHex:
+		res := make([]byte, hex.DecodedLen(len(line)))
+		if _, err := hex.Decode(res, line); err != nil {
+			return nil, fmt.Errorf("invalid hex: %w", err)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", e)
+	}
+}
+
+func decodeBase64(enc *base64.Encoding, line []byte) ([]byte, error) {
+	line = bytes.TrimRight(line, "=")
+
+	res := make([]byte, enc.DecodedLen(len(line)))
+	n, err := enc.Decode(res, line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	return res[:n], nil
+}
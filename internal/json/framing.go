@@ -0,0 +1,125 @@
+package json
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Framing is how messages are delimited in a stream.
+type Framing string
+
+const (
+	// FramingMarker delimits messages with the end of message marker, the default.
+	FramingMarker Framing = "marker"
+	// FramingVarint prefixes each message with its length as a varint, like Java's writeDelimitedTo.
+	FramingVarint Framing = "varint"
+	// FramingUint32BE prefixes each message with its length as a 4-byte big-endian integer.
+	FramingUint32BE Framing = "uint32be"
+	// FramingUint32LE prefixes each message with its length as a 4-byte little-endian integer.
+	FramingUint32LE Framing = "uint32le"
+	// FramingSingle reads the whole stream as a single message.
+	FramingSingle Framing = "single"
+)
+
+// Framings are all the supported framings.
+var Framings = []Framing{FramingMarker, FramingVarint, FramingUint32BE, FramingUint32LE, FramingSingle}
+
+// ParseFraming returns the framing with the given name.
+func ParseFraming(name string) (Framing, error) {
+	i, err := lookup("framing", name, len(Framings), func(i int) string { return string(Framings[i]) })
+	if err != nil {
+		return "", err
+	}
+	return Framings[i], nil
+}
+
+// SplitFunc returns the split function of a Scanner that returns each message of the stream.
+// The marker only applies to the marker framing.
+func (f Framing) SplitFunc(marker []byte) (bufio.SplitFunc, error) {
+	if f != "" && f != FramingMarker && len(marker) > 0 {
+		return nil, fmt.Errorf("the end of message marker can't be combined with the %s framing", f)
+	}
+
+	switch f {
+	case "", FramingMarker:
+		return splitMessagesOnMarker(marker), nil
+	case FramingVarint:
+		return splitLengthPrefixed(varintLength), nil
+	case FramingUint32BE:
+		return splitLengthPrefixed(uint32Length(binary.BigEndian)), nil
+	case FramingUint32LE:
+		return splitLengthPrefixed(uint32Length(binary.LittleEndian)), nil
+	case FramingSingle:
+		return splitSingle, nil
+	default:
+		return nil, fmt.Errorf("unknown framing %q", f)
+	}
+}
+
+// lengthFunc reads the length prefix at the start of data. It returns the size of the prefix along with the length,
+// or a zero size if data doesn't hold the whole prefix yet.
+type lengthFunc func(data []byte) (length uint64, size int, err error)
+
+func varintLength(data []byte) (uint64, int, error) {
+	length, n := binary.Uvarint(data)
+	if n < 0 {
+		return 0, 0, errors.New("the varint length prefix overflows 64 bits")
+	}
+	return length, n, nil
+}
+
+func uint32Length(order binary.ByteOrder) lengthFunc {
+	return func(data []byte) (uint64, int, error) {
+		if len(data) < 4 {
+			return 0, 0, nil
+		}
+		return uint64(order.Uint32(data)), 4, nil
+	}
+}
+
+// splitLengthPrefixed is a split function for a Scanner that returns each message of a stream
+// where every message is prefixed with its length. Messages can be empty.
+func splitLengthPrefixed(readLength lengthFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+
+		length, n, err := readLength(data)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n == 0 {
+			if atEOF {
+				return 0, nil, fmt.Errorf("truncated length prefix of %d bytes", len(data))
+			}
+			// Request more data.
+			return 0, nil, nil
+		}
+
+		if length > uint64(len(data)-n) {
+			if atEOF {
+				return 0, nil, fmt.Errorf("truncated message: expected %d bytes, got %d", length, len(data)-n)
+			}
+			// Request more data.
+			return 0, nil, nil
+		}
+
+		end := n + int(length)
+		return end, data[n:end], nil
+	}
+}
+
+// splitSingle is a split function for a Scanner that returns the whole stream as a single message.
+func splitSingle(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if !atEOF {
+		// Request more data.
+		return 0, nil, nil
+	}
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+	return len(data), data, nil
+}
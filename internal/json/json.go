@@ -3,13 +3,56 @@ package json
 import (
 	"bufio"
 	"bytes"
+	stdjson "encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
+	"gopkg.in/yaml.v3"
 )
 
+// Format is the format messages are converted to.
+type Format string
+
+const (
+	// FormatJSON is compact json, the default.
+	FormatJSON Format = "json"
+	// FormatJSONIndent is indented json.
+	FormatJSONIndent Format = "json-indent"
+	// FormatYAML is yaml, with the fields in the same order as in json.
+	FormatYAML Format = "yaml"
+	// FormatTextProto is the protobuf text format.
+	FormatTextProto Format = "textproto"
+)
+
+// Formats are all the supported formats.
+var Formats = []Format{FormatJSON, FormatJSONIndent, FormatYAML, FormatTextProto}
+
+// ParseFormat returns the format with the given name.
+func ParseFormat(name string) (Format, error) {
+	i, err := lookup("format", name, len(Formats), func(i int) string { return string(Formats[i]) })
+	if err != nil {
+		return "", err
+	}
+	return Formats[i], nil
+}
+
+// lookup returns the index of the given name among the n names of a kind of option, e.g. the formats.
+func lookup(kind, name string, n int, nameAt func(int) string) (int, error) {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = nameAt(i)
+		if names[i] == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown %s %q, expected one of %s", kind, name, strings.Join(names, ", "))
+}
+
 // ProtoParser defines the interface for parsing proto files dynamically.
 type ProtoParser interface {
 	ParseFiles(filenames ...string) ([]*desc.FileDescriptor, error)
@@ -22,6 +65,35 @@ type Converter struct {
 	Package, MessageType string
 	Indent               bool
 	EndOfMessageMarker   string
+	// Format is the format messages are converted to. Defaults to json, indented if Indent is set.
+	Format Format
+	// Options are the options of the json and yaml formats.
+	Options MarshalOptions
+	// Framing is how ConvertStream delimits messages. Defaults to the end of message marker.
+	Framing Framing
+	// Encoding is how ConvertStream reads messages. Defaults to raw, text encodings are one message per line.
+	Encoding Encoding
+	// EmbeddedPath makes ConvertStream read json lines instead, e.g. logs, and replace the message encoded at this path,
+	// e.g. .payload, with the converted message. Embedded messages are base64 encoded, unless Encoding is a text encoding.
+	EmbeddedPath string
+	// KcatEnvelope makes ConvertStream read the json envelopes of kcat -J instead, and replace their payload
+	// with the converted message.
+	KcatEnvelope bool
+	// KeyDecoder decodes the keys of kcat envelopes, which are left as they are if it's nil.
+	KeyDecoder *Decoder
+}
+
+// MarshalOptions are the options of converting messages to json, the same as the ones of jsonpb.Marshaler.
+type MarshalOptions struct {
+	// EmitDefaults emits the fields with zero values, which are omitted otherwise.
+	EmitDefaults bool
+	// OrigName uses the field names of the proto file instead of the camelCased json names.
+	OrigName bool
+	// EnumsAsInts emits enums as their numbers instead of their names.
+	EnumsAsInts bool
+	// Int64AsNumbers emits 64-bit integers as numbers instead of strings. Numbers over 2^53 lose precision
+	// in many json parsers, e.g. javascript, which is why they are strings in protobuf json.
+	Int64AsNumbers bool
 }
 
 // ConvertStream converts multiple proto messages to json.
@@ -29,12 +101,22 @@ type Converter struct {
 // Because proto messages often contain newlines, we can't rely on new lines for knowing when one message ends and the
 // next begins, so instead it looks for a line containing only a specified marker (defaults to DefaultEndOfMessageMarker).
 // Although unlikely, it is possible that the EndOfMessageMarker can be part of the proto binary message, in which case the
-// parsing of that message will fail. If this happens, use a more complex EndOfMessageMarker.
+// parsing of that message will fail. If this happens, use a more complex EndOfMessageMarker, or a length-prefixed Framing.
+// Text encoded messages, json lines with embedded messages and kcat envelopes are one per line instead,
+// blank lines are skipped and errors tell the line number.
 func (c Converter) ConvertStream(r io.Reader) (resultCh chan []byte, errorCh chan error) {
 	resultCh = make(chan []byte)
 	errorCh = make(chan error)
 
-	md, err := c.createProtoMessageDescriptor()
+	var md *desc.MessageDescriptor
+	var path []string
+	split, err := c.splitFunc()
+	if err == nil && c.EmbeddedPath != "" {
+		path, err = parseEmbeddedPath(c.EmbeddedPath)
+	}
+	if err == nil {
+		md, err = c.createProtoMessageDescriptor()
+	}
 	if err != nil {
 		go func() {
 			errorCh <- err
@@ -48,17 +130,43 @@ func (c Converter) ConvertStream(r io.Reader) (resultCh chan []byte, errorCh cha
 		scanner := bufio.NewScanner(r)
 		// Don't set an initial buffer, as the default scanner doesn't do so either
 		scanner.Buffer(nil, 1024*1024)
-		scanner.Split(splitMessagesOnMarker([]byte(c.EndOfMessageMarker)))
+		scanner.Split(split)
+		line := 0
 		for scanner.Scan() {
 			rawBytes := scanner.Bytes()
-			parsed, err := c.unmarshalProtoBytesToJSON(md, rawBytes)
+			if !c.byLine() {
+				parsed, err := c.unmarshalProtoBytesToJSON(md, rawBytes)
+				if err != nil {
+					errorCh <- err
+				} else {
+					resultCh <- parsed
+				}
+				continue
+			}
+
+			line++
+			if len(bytes.TrimSpace(rawBytes)) == 0 {
+				continue
+			}
+			var parsed []byte
+			switch {
+			case c.KcatEnvelope:
+				parsed, err = c.convertKcat(md, rawBytes)
+			case path != nil:
+				parsed, err = c.convertEmbedded(md, path, rawBytes)
+			default:
+				parsed, err = c.unmarshalTextToJSON(md, rawBytes)
+			}
 			if err != nil {
-				errorCh <- err
+				errorCh <- fmt.Errorf("line %d: %w", line, err)
 			} else {
 				resultCh <- parsed
 			}
 		}
 		if err := scanner.Err(); err != nil {
+			if c.byLine() {
+				err = fmt.Errorf("line %d: %w", line+1, err)
+			}
 			errorCh <- err
 		}
 		close(resultCh)
@@ -67,6 +175,123 @@ func (c Converter) ConvertStream(r io.Reader) (resultCh chan []byte, errorCh cha
 	return
 }
 
+// splitFunc returns the split function of the stream, by line for text encoded messages or by the framing otherwise.
+func (c Converter) splitFunc() (bufio.SplitFunc, error) {
+	if !c.byLine() {
+		return c.Framing.SplitFunc([]byte(c.EndOfMessageMarker))
+	}
+
+	if c.Encoding != "" {
+		if _, err := ParseEncoding(string(c.Encoding)); err != nil {
+			return nil, err
+		}
+	}
+
+	what := fmt.Sprintf("%s encoded messages are", c.Encoding)
+	switch {
+	case c.KcatEnvelope:
+		what = "kcat envelopes are"
+		if c.EmbeddedPath != "" || c.Encoding.isText() {
+			return nil, errors.New("kcat envelopes can't be combined with an embedded path or an encoding")
+		}
+	case c.EmbeddedPath != "":
+		what = "json lines with embedded messages are"
+	}
+	if c.Format == FormatTextProto && (c.KcatEnvelope || c.EmbeddedPath != "") {
+		return nil, fmt.Errorf("%s json, they can't be converted to textproto", what)
+	}
+	if (c.Framing != "" && c.Framing != FramingMarker) || c.EndOfMessageMarker != "" {
+		return nil, fmt.Errorf("%s one per line, they can't be combined with a framing or a marker", what)
+	}
+	return bufio.ScanLines, nil
+}
+
+// byLine tells whether the stream is read line by line, rather than delimited by the framing.
+func (c Converter) byLine() bool {
+	return c.Encoding.isText() || c.EmbeddedPath != "" || c.KcatEnvelope
+}
+
+func (c Converter) unmarshalTextToJSON(md *desc.MessageDescriptor, line []byte) ([]byte, error) {
+	rawMessage, err := c.Encoding.decode(line)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.unmarshalProtoBytesToJSON(md, rawMessage)
+}
+
+// Decoder decodes single proto messages to json, reusing a message descriptor that is resolved only once.
+type Decoder struct {
+	converter Converter
+	md        *desc.MessageDescriptor
+}
+
+// NewDecoder parses the proto file and resolves the message descriptor once, so that the returned decoder
+// can convert any number of messages without parsing the proto file again.
+func (c Converter) NewDecoder() (*Decoder, error) {
+	md, err := c.createProtoMessageDescriptor()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoder{converter: c, md: md}, nil
+}
+
+// NewDescriptorDecoder returns a decoder for a message descriptor that was resolved elsewhere, e.g. from a schema registry.
+// Parser, Filename, Package and MessageType of the converter are ignored.
+func (c Converter) NewDescriptorDecoder(md *desc.MessageDescriptor) *Decoder {
+	return &Decoder{converter: c, md: md}
+}
+
+// NewDecoders parses the proto file and returns a decoder for each message type found in it, or in the files it imports,
+// including nested message types. Decoders are keyed by their fully qualified message type, e.g. tutorial.Person.
+// Package and MessageType of the converter are ignored.
+func (c Converter) NewDecoders() (map[string]*Decoder, error) {
+	files, err := c.Parser.ParseFiles(c.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decoders := map[string]*Decoder{}
+	seen := map[string]bool{}
+
+	var addFile func(fd *desc.FileDescriptor)
+	var addMessages func(mds []*desc.MessageDescriptor)
+	addMessages = func(mds []*desc.MessageDescriptor) {
+		for _, md := range mds {
+			decoders[md.GetFullyQualifiedName()] = &Decoder{converter: c, md: md}
+			addMessages(md.GetNestedMessageTypes())
+		}
+	}
+	addFile = func(fd *desc.FileDescriptor) {
+		if seen[fd.GetName()] {
+			return
+		}
+		seen[fd.GetName()] = true
+
+		addMessages(fd.GetMessageTypes())
+		for _, dep := range fd.GetDependencies() {
+			addFile(dep)
+		}
+	}
+
+	for _, fd := range files {
+		addFile(fd)
+	}
+
+	return decoders, nil
+}
+
+// Decode converts a single proto message to json, or to the format of the converter, synchronously.
+func (d *Decoder) Decode(rawData []byte) (string, error) {
+	json, err := d.converter.unmarshalProtoBytesToJSON(d.md, rawData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(json), nil
+}
+
 func (c Converter) createProtoMessageDescriptor() (*desc.MessageDescriptor, error) {
 	files, err := c.Parser.ParseFiles(c.Filename)
 	if err != nil {
@@ -109,11 +334,87 @@ func (c Converter) unmarshalProtoBytesToJSON(md *desc.MessageDescriptor, rawMess
 }
 
 func (c Converter) marshalJSON(dm *dynamic.Message) ([]byte, error) {
-	if c.Indent {
-		return dm.MarshalJSONIndent()
+	switch c.Format {
+	case "", FormatJSON:
+		if c.Indent {
+			return c.marshalJSONPB(dm, "  ")
+		}
+		return c.marshalJSONPB(dm, "")
+	case FormatJSONIndent:
+		return c.marshalJSONPB(dm, "  ")
+	case FormatYAML:
+		b, err := c.marshalJSONPB(dm, "")
+		if err != nil {
+			return nil, err
+		}
+		return jsonToYAML(b)
+	case FormatTextProto:
+		b, err := dm.MarshalTextIndent()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(b, []byte("\n")), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", c.Format)
 	}
+}
 
-	return dm.MarshalJSON()
+func (c Converter) marshalJSONPB(dm *dynamic.Message, indent string) ([]byte, error) {
+	m := &jsonpb.Marshaler{
+		EmitDefaults: c.Options.EmitDefaults,
+		OrigName:     c.Options.OrigName,
+		EnumsAsInts:  c.Options.EnumsAsInts,
+	}
+	if !c.Options.Int64AsNumbers {
+		m.Indent = indent
+		return dm.MarshalJSONPB(m)
+	}
+
+	b, err := dm.MarshalJSONPB(m)
+	if err != nil {
+		return nil, err
+	}
+	b, err = int64AsNumbers(b, dm.GetMessageDescriptor())
+	if err != nil || indent == "" {
+		return b, err
+	}
+
+	var buf bytes.Buffer
+	if err := stdjson.Indent(&buf, b, "", indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonToYAML converts json to block style yaml. Decoding into a node rather than a map keeps the order of the fields,
+// and 64-bit integers, which are strings in json, stay strings.
+func jsonToYAML(b []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, err
+	}
+	resetStyle(&node)
+
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(2)
+	if err := e.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// resetStyle drops the flow style and the quotes of json, so that the yaml encoder picks the plain style
+// and only quotes the strings that need it.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		resetStyle(n)
+	}
 }
 
 // splitMessagesOnMarker is a split function for a Scanner that returns each msg
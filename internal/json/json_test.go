@@ -3,7 +3,10 @@ package json
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -12,8 +15,12 @@ import (
 	"github.com/beatlabs/proton/v2/internal/protoparser"
 	another_tutorial "github.com/beatlabs/proton/v2/testdata"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	json "google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -256,6 +263,348 @@ func Test_ConvertStream_WithInvalidProtoFile(t *testing.T) {
 
 }
 
+func Test_Decoder(t *testing.T) {
+	protoBytes, err := proto.Marshal(genAddressBook())
+	assert.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	assert.NoError(t, err)
+
+	addressBookAsJSONBytes, err := json.MarshalOptions{}.Marshal(genAddressBook())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		converter Converter
+		input     []byte
+		result    []byte
+		newErr    string
+		decodeErr string
+	}{
+		{
+			name:      "valid message",
+			converter: Converter{Parser: parser, Filename: filename},
+			input:     protoBytes,
+			result:    addressBookAsJSONBytes,
+		},
+		{
+			name:      "invalid message",
+			converter: Converter{Parser: parser, Filename: filename},
+			input:     []byte("\n"),
+			decodeErr: "unexpected EOF",
+		},
+		{
+			name:      "wrong type",
+			converter: Converter{Parser: parser, Filename: filename, MessageType: "AddressBook2"},
+			newErr:    "can't find AddressBook2 in tutorial package",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := test.converter.NewDecoder()
+			if test.newErr != "" {
+				assert.EqualError(t, err, test.newErr)
+				return
+			}
+			assert.NoError(t, err)
+
+			// decoding twice makes sure the decoder is reusable
+			for i := 0; i < 2; i++ {
+				res, err := d.Decode(test.input)
+				if test.decodeErr != "" {
+					assert.EqualError(t, err, test.decodeErr)
+					continue
+				}
+				assert.NoError(t, err)
+				assert.JSONEq(t, string(test.result), res)
+			}
+		})
+	}
+}
+
+func TestDecoder_Formats(t *testing.T) {
+	protoBytes, err := proto.Marshal(genAddressBook().People[0])
+	require.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		format   Format
+		indent   bool
+		expected string
+		err      string
+	}{
+		{
+			name:     "json by default",
+			expected: `{"name":"ABC","id":1,"email":"abc@thebeat.co","phones":[{"number":"123456","type":"HOME"}],"lastUpdated":"2013-01-02T09:22:00Z"}`,
+		},
+		{
+			name:     "indented json by default with indent",
+			indent:   true,
+			expected: "{\n  \"name\": \"ABC\",\n  \"id\": 1,\n  \"email\": \"abc@thebeat.co\",\n  \"phones\": [\n    {\n      \"number\": \"123456\",\n      \"type\": \"HOME\"\n    }\n  ],\n  \"lastUpdated\": \"2013-01-02T09:22:00Z\"\n}",
+		},
+		{
+			name:     "json",
+			format:   FormatJSON,
+			expected: `{"name":"ABC","id":1,"email":"abc@thebeat.co","phones":[{"number":"123456","type":"HOME"}],"lastUpdated":"2013-01-02T09:22:00Z"}`,
+		},
+		{
+			name:     "indented json",
+			format:   FormatJSONIndent,
+			expected: "{\n  \"name\": \"ABC\",\n  \"id\": 1,\n  \"email\": \"abc@thebeat.co\",\n  \"phones\": [\n    {\n      \"number\": \"123456\",\n      \"type\": \"HOME\"\n    }\n  ],\n  \"lastUpdated\": \"2013-01-02T09:22:00Z\"\n}",
+		},
+		{
+			name:   "yaml keeps the field order and quotes strings that look like numbers",
+			format: FormatYAML,
+			expected: `name: ABC
+id: 1
+email: abc@thebeat.co
+phones:
+  - number: "123456"
+    type: HOME
+lastUpdated: "2013-01-02T09:22:00Z"`,
+		},
+		{
+			name:   "textproto",
+			format: FormatTextProto,
+			expected: `name: "ABC"
+id: 1
+email: "abc@thebeat.co"
+phones: <
+  number: "123456"
+  type: HOME
+>
+last_updated: <
+  seconds:1357118520 
+>`,
+		},
+		{
+			name:   "unknown format",
+			format: "xml",
+			err:    `unknown format "xml"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			c := Converter{Parser: parser, Filename: filename, MessageType: "Person", Indent: test.indent, Format: test.format}
+			d, err := c.NewDecoder()
+			require.NoError(t, err)
+
+			// when
+			res, err := d.Decode(protoBytes)
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestDecoder_MarshalOptions(t *testing.T) {
+	parser := protoparse.Parser{
+		Accessor: protoparse.FileContentsFromMap(map[string]string{"amounts.proto": `
+syntax = "proto3";
+package amounts;
+import "google/protobuf/wrappers.proto";
+
+message Amount {
+	enum Currency {
+		EUR = 0;
+		USD = 1;
+	}
+	message Part {
+		fixed64 cents = 1;
+	}
+	int64 units = 1;
+	Currency currency = 2;
+	uint64 total_units = 3;
+	repeated sint64 history = 4;
+	map<string, int64> by_country = 5;
+	repeated Part parts = 6;
+	google.protobuf.Int64Value limit = 7;
+	string note = 8;
+	int32 id = 9;
+}
+`}),
+	}
+
+	fds, err := parser.ParseFiles("amounts.proto")
+	require.NoError(t, err)
+	md := fds[0].FindMessage("amounts.Amount")
+	dm := dynamic.NewMessage(md)
+	require.NoError(t, dm.UnmarshalJSON([]byte(`{"units":"9007199254740993","currency":"USD","totalUnits":"18446744073709551615",`+
+		`"history":["-1","2"],"byCountry":{"GR":"3"},"parts":[{"cents":"4"}],"limit":"5","id":0}`)))
+	protoBytes, err := dm.Marshal()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		format   Format
+		options  MarshalOptions
+		expected string
+	}{
+		{
+			name: "defaults",
+			expected: `{"units":"9007199254740993","currency":"USD","totalUnits":"18446744073709551615","history":["-1","2"],` +
+				`"byCountry":{"GR":"3"},"parts":[{"cents":"4"}],"limit":"5"}`,
+		},
+		{
+			name:    "emit defaults",
+			options: MarshalOptions{EmitDefaults: true},
+			expected: `{"units":"9007199254740993","currency":"USD","totalUnits":"18446744073709551615","history":["-1","2"],` +
+				`"byCountry":{"GR":"3"},"parts":[{"cents":"4"}],"limit":"5","note":"","id":0}`,
+		},
+		{
+			name:    "orig names",
+			options: MarshalOptions{OrigName: true},
+			expected: `{"units":"9007199254740993","currency":"USD","total_units":"18446744073709551615","history":["-1","2"],` +
+				`"by_country":{"GR":"3"},"parts":[{"cents":"4"}],"limit":"5"}`,
+		},
+		{
+			name:    "enums as ints",
+			options: MarshalOptions{EnumsAsInts: true},
+			expected: `{"units":"9007199254740993","currency":1,"totalUnits":"18446744073709551615","history":["-1","2"],` +
+				`"byCountry":{"GR":"3"},"parts":[{"cents":"4"}],"limit":"5"}`,
+		},
+		{
+			name:    "int64 as numbers",
+			options: MarshalOptions{Int64AsNumbers: true},
+			expected: `{"units":9007199254740993,"currency":"USD","totalUnits":18446744073709551615,"history":[-1,2],` +
+				`"byCountry":{"GR":3},"parts":[{"cents":4}],"limit":5}`,
+		},
+		{
+			name:    "all options",
+			options: MarshalOptions{EmitDefaults: true, OrigName: true, EnumsAsInts: true, Int64AsNumbers: true},
+			expected: `{"units":9007199254740993,"currency":1,"total_units":18446744073709551615,"history":[-1,2],` +
+				`"by_country":{"GR":3},"parts":[{"cents":4}],"limit":5,"note":"","id":0}`,
+		},
+		{
+			name:     "int64 as numbers indented",
+			format:   FormatJSONIndent,
+			options:  MarshalOptions{Int64AsNumbers: true},
+			expected: "{\n  \"units\": 9007199254740993,\n  \"currency\": \"USD\",\n  \"totalUnits\": 18446744073709551615,\n  \"history\": [\n    -1,\n    2\n  ],\n  \"byCountry\": {\n    \"GR\": 3\n  },\n  \"parts\": [\n    {\n      \"cents\": 4\n    }\n  ],\n  \"limit\": 5\n}",
+		},
+		{
+			name:     "yaml",
+			format:   FormatYAML,
+			options:  MarshalOptions{OrigName: true, Int64AsNumbers: true},
+			expected: "units: 9007199254740993\ncurrency: USD\ntotal_units: 18446744073709551615\nhistory:\n  - -1\n  - 2\nby_country:\n  GR: 3\nparts:\n  - cents: 4\nlimit: 5",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			d, err := Converter{Parser: parser, Filename: "amounts.proto", Format: test.format, Options: test.options}.NewDecoder()
+			require.NoError(t, err)
+
+			// when
+			res, err := d.Decode(protoBytes)
+
+			// then
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range Formats {
+		res, err := ParseFormat(string(f))
+		assert.NoError(t, err)
+		assert.Equal(t, f, res)
+	}
+
+	_, err := ParseFormat("xml")
+	assert.EqualError(t, err, `unknown format "xml", expected one of json, json-indent, yaml, textproto`)
+}
+
+func TestConverter_NewDecoders(t *testing.T) {
+	// given
+	protoBytes, err := proto.Marshal(genAddressBook().People[0])
+	require.NoError(t, err)
+
+	personAsJSONBytes, err := json.MarshalOptions{}.Marshal(genAddressBook().People[0])
+	require.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	// when
+	decoders, err := Converter{Parser: parser, Filename: filename}.NewDecoders()
+
+	// then
+	require.NoError(t, err)
+	assert.Contains(t, decoders, "tutorial.AddressBook")
+	assert.Contains(t, decoders, "tutorial.Person.PhoneNumber", "nested message types are included")
+	assert.Contains(t, decoders, "google.protobuf.Timestamp", "imported message types are included")
+
+	require.Contains(t, decoders, "tutorial.Person")
+	res, err := decoders["tutorial.Person"].Decode(protoBytes)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(personAsJSONBytes), res)
+}
+
+// BenchmarkDecoder_Decode measures the per-message cost of a decoder that resolved its descriptor once.
+// The cost per operation stays the same no matter how many messages have been decoded before.
+func BenchmarkDecoder_Decode(b *testing.B) {
+	protoBytes, err := proto.Marshal(genAddressBook())
+	assert.NoError(b, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	assert.NoError(b, err)
+
+	d, err := Converter{Parser: parser, Filename: filename}.NewDecoder()
+	assert.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.Decode(protoBytes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkConverter_ConvertStreamPerMessage measures the cost of converting every message with its own stream,
+// which parses the proto file again for each of them.
+func BenchmarkConverter_ConvertStreamPerMessage(b *testing.B) {
+	protoBytes, err := proto.Marshal(genAddressBook())
+	assert.NoError(b, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	assert.NoError(b, err)
+
+	c := Converter{Parser: parser, Filename: filename}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resultCh, errorCh := c.ConvertStream(bytes.NewReader(protoBytes))
+		for resultCh != nil || errorCh != nil {
+			select {
+			case _, ok := <-resultCh:
+				if !ok {
+					resultCh = nil
+				}
+			case err, ok := <-errorCh:
+				if !ok {
+					errorCh = nil
+					continue
+				}
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
 func TestSplitting(t *testing.T) {
 	tests := map[string]struct {
 		input        []byte
@@ -334,6 +683,512 @@ func TestSplitting(t *testing.T) {
 	}
 }
 
+func TestFraming_SplitFunc(t *testing.T) {
+	msg1 := []byte{1, 2, '\n', 3}
+	msg2 := bytes.Repeat([]byte{'-'}, 300)
+
+	tests := map[string]struct {
+		framing      Framing
+		marker       []byte
+		input        []byte
+		expectedMsgs [][]byte
+		err          string
+	}{
+		"marker by default": {
+			marker:       []byte("--END--"),
+			input:        appendSlices(msg1, []byte("--END--"), msg2),
+			expectedMsgs: [][]byte{msg1, msg2},
+		},
+		"varint": {
+			framing:      FramingVarint,
+			input:        appendSlices([]byte{4}, msg1, []byte{0xac, 0x02}, msg2),
+			expectedMsgs: [][]byte{msg1, msg2},
+		},
+		"varint with an empty message": {
+			framing:      FramingVarint,
+			input:        appendSlices([]byte{0, 4}, msg1),
+			expectedMsgs: [][]byte{{}, msg1},
+		},
+		"uint32be": {
+			framing:      FramingUint32BE,
+			input:        appendSlices([]byte{0, 0, 0, 4}, msg1, []byte{0, 0, 1, 0x2c}, msg2),
+			expectedMsgs: [][]byte{msg1, msg2},
+		},
+		"uint32le": {
+			framing:      FramingUint32LE,
+			input:        appendSlices([]byte{4, 0, 0, 0}, msg1, []byte{0x2c, 1, 0, 0}, msg2),
+			expectedMsgs: [][]byte{msg1, msg2},
+		},
+		"single": {
+			framing:      FramingSingle,
+			input:        appendSlices(msg1, []byte("--END--"), msg2),
+			expectedMsgs: [][]byte{appendSlices(msg1, []byte("--END--"), msg2)},
+		},
+		"empty": {
+			framing:      FramingVarint,
+			input:        []byte{},
+			expectedMsgs: [][]byte{},
+		},
+		"truncated message": {
+			framing:      FramingUint32BE,
+			input:        appendSlices([]byte{0, 0, 0, 4}, msg1, []byte{0, 0, 0, 5}, msg1),
+			expectedMsgs: [][]byte{msg1},
+			err:          "truncated message: expected 5 bytes, got 4",
+		},
+		"truncated length prefix": {
+			framing:      FramingUint32LE,
+			input:        []byte{4, 0},
+			expectedMsgs: [][]byte{},
+			err:          "truncated length prefix of 2 bytes",
+		},
+		"truncated varint length prefix": {
+			framing:      FramingVarint,
+			input:        []byte{0xac},
+			expectedMsgs: [][]byte{},
+			err:          "truncated length prefix of 1 bytes",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			split, err := tt.framing.SplitFunc(tt.marker)
+			require.NoError(t, err)
+
+			s := bufio.NewScanner(bytes.NewReader(tt.input))
+			s.Split(split)
+			msgs := make([][]byte, 0)
+			for s.Scan() {
+				msgs = append(msgs, append([]byte{}, s.Bytes()...))
+			}
+
+			assert.Equal(t, tt.expectedMsgs, msgs)
+			if tt.err != "" {
+				assert.EqualError(t, s.Err(), tt.err)
+				return
+			}
+			assert.NoError(t, s.Err())
+		})
+	}
+}
+
+func TestFraming_SplitFuncInvalid(t *testing.T) {
+	_, err := FramingVarint.SplitFunc([]byte("--END--"))
+	assert.EqualError(t, err, "the end of message marker can't be combined with the varint framing")
+
+	_, err = Framing("xml").SplitFunc(nil)
+	assert.EqualError(t, err, `unknown framing "xml"`)
+
+	_, err = ParseFraming("xml")
+	assert.EqualError(t, err, `unknown framing "xml", expected one of marker, varint, uint32be, uint32le, single`)
+}
+
+func Test_ConvertStream_Framing(t *testing.T) {
+	// given
+	protoBytes, err := proto.Marshal(genAddressBook())
+	require.NoError(t, err)
+	addressBookAsJSONBytes, err := json.MarshalOptions{}.Marshal(genAddressBook())
+	require.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	var input []byte
+	for i := 0; i < 2; i++ {
+		input = protowire.AppendVarint(input, uint64(len(protoBytes)))
+		input = append(input, protoBytes...)
+	}
+
+	// when
+	resultCh, errorCh := Converter{Parser: parser, Filename: filename, Framing: FramingVarint}.ConvertStream(bytes.NewReader(input))
+
+	// then
+	var results []string
+	for res := range resultCh {
+		results = append(results, string(res))
+	}
+	for err := range errorCh {
+		assert.NoError(t, err)
+	}
+	require.Len(t, results, 2)
+	for _, res := range results {
+		assert.JSONEq(t, string(addressBookAsJSONBytes), res)
+	}
+}
+
+func Test_ConvertStream_Encoding(t *testing.T) {
+	protoBytes, err := proto.Marshal(genAddressBook().People[0])
+	require.NoError(t, err)
+	personAsJSON, err := json.MarshalOptions{}.Marshal(genAddressBook().People[0])
+	require.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	b64 := base64.StdEncoding.EncodeToString(protoBytes)
+	b64URL := base64.URLEncoding.EncodeToString(protoBytes)
+	hexString := hex.EncodeToString(protoBytes)
+
+	tests := []struct {
+		name      string
+		converter Converter
+		input     string
+		results   int
+		errors    []string
+	}{
+		{
+			name:      "base64 with and without padding",
+			converter: Converter{Encoding: EncodingBase64},
+			input:     b64 + "\n" + strings.TrimRight(b64, "=") + "\n",
+			results:   2,
+		},
+		{
+			name:      "url-safe base64",
+			converter: Converter{Encoding: EncodingBase64URL},
+			input:     b64URL,
+			results:   1,
+		},
+		{
+			name:      "hex with blank lines and whitespace",
+			converter: Converter{Encoding: EncodingHex},
+			input:     "\n  " + hexString + "\r\n\n" + strings.ToUpper(hexString) + "\n",
+			results:   2,
+		},
+		{
+			name:      "errors tell the line",
+			converter: Converter{Encoding: EncodingBase64},
+			input:     b64 + "\n\nnot base64!\n" + base64.StdEncoding.EncodeToString([]byte("\n")) + "\n",
+			results:   1,
+			errors: []string{
+				"line 3: invalid base64: illegal base64 data at input byte 3",
+				"line 4: unexpected EOF",
+			},
+		},
+		{
+			name:      "odd hex",
+			converter: Converter{Encoding: EncodingHex},
+			input:     "abc",
+			errors:    []string{"line 1: invalid hex: encoding/hex: odd length hex string"},
+		},
+		{
+			name:      "framing",
+			converter: Converter{Encoding: EncodingHex, Framing: FramingVarint},
+			errors:    []string{"hex encoded messages are one per line, they can't be combined with a framing or a marker"},
+		},
+		{
+			name:      "marker",
+			converter: Converter{Encoding: EncodingBase64, EndOfMessageMarker: marker},
+			errors:    []string{"base64 encoded messages are one per line, they can't be combined with a framing or a marker"},
+		},
+		{
+			name:      "unknown encoding",
+			converter: Converter{Encoding: "base32"},
+			errors:    []string{`unknown encoding "base32", expected one of raw, base64, base64url, hex`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			c := test.converter
+			c.Parser, c.Filename, c.MessageType = parser, filename, "Person"
+
+			// when
+			resultCh, errorCh := c.ConvertStream(strings.NewReader(test.input))
+
+			// then
+			results, errs := collect(resultCh, errorCh)
+			require.Len(t, results, test.results)
+			for _, res := range results {
+				assert.JSONEq(t, string(personAsJSON), string(res))
+			}
+			var errStrings []string
+			for _, err := range errs {
+				errStrings = append(errStrings, err.Error())
+			}
+			assert.Equal(t, test.errors, errStrings)
+		})
+	}
+}
+
+func Test_ConvertStream_EmbeddedPath(t *testing.T) {
+	protoBytes, err := proto.Marshal(&another_tutorial.Person{Name: "ABC", Id: 1})
+	require.NoError(t, err)
+	b64 := base64.StdEncoding.EncodeToString(protoBytes)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		converter Converter
+		input     string
+		results   []string
+		errors    []string
+	}{
+		{
+			name:      "other fields are passed through in order",
+			converter: Converter{EmbeddedPath: ".payload"},
+			input:     `{"ts":1646218065015, "payload":"` + b64 + `", "level":"info","tags":{"a":[1, 2]}}` + "\n\n" + `{"payload":"` + b64 + `"}`,
+			results: []string{
+				`{"ts":1646218065015,"payload":{"name":"ABC","id":1},"level":"info","tags":{"a":[1, 2]}}`,
+				`{"payload":{"name":"ABC","id":1}}`,
+			},
+		},
+		{
+			name:      "nested path",
+			converter: Converter{EmbeddedPath: ".event.payload"},
+			input:     `{"payload":"not this one","event":{"type":"person","payload":"` + b64 + `"}}`,
+			results:   []string{`{"payload":"not this one","event":{"type":"person","payload":{"name":"ABC","id":1}}}`},
+		},
+		{
+			name:      "null message stays null",
+			converter: Converter{EmbeddedPath: ".payload"},
+			input:     `{"ts":1,"payload":null}`,
+			results:   []string{`{"ts":1,"payload":null}`},
+		},
+		{
+			name:      "hex encoding",
+			converter: Converter{EmbeddedPath: ".payload", Encoding: EncodingHex},
+			input:     `{"payload":"` + hex.EncodeToString(protoBytes) + `"}`,
+			results:   []string{`{"payload":{"name":"ABC","id":1}}`},
+		},
+		{
+			name:      "indented",
+			converter: Converter{EmbeddedPath: ".payload", Format: FormatJSONIndent},
+			input:     `{"ts":1,"payload":"` + b64 + `"}`,
+			results:   []string{"{\n  \"ts\": 1,\n  \"payload\": {\n    \"name\": \"ABC\",\n    \"id\": 1\n  }\n}"},
+		},
+		{
+			name:      "yaml",
+			converter: Converter{EmbeddedPath: ".payload", Format: FormatYAML},
+			input:     `{"ts":1,"payload":"` + b64 + `"}`,
+			results:   []string{"ts: 1\npayload:\n  name: ABC\n  id: 1"},
+		},
+		{
+			name:      "errors tell the line",
+			converter: Converter{EmbeddedPath: ".event.payload"},
+			input: `{"event":{"payload":"` + b64 + `"}}` + "\n" +
+				`{"event":"payload"}` + "\n" +
+				`{"event":{"payload":1}}` + "\n" +
+				`{"event":{"payload":"!"}}` + "\n" +
+				`not json`,
+			results: []string{`{"event":{"payload":{"name":"ABC","id":1}}}`},
+			errors: []string{
+				"line 2: there's no .event.payload in the line",
+				"line 3: the value at .event.payload isn't a string",
+				"line 4: invalid base64: illegal base64 data at input byte 0",
+				"line 5: invalid character 'o' in literal null (expecting 'u')",
+			},
+		},
+		{
+			name:      "invalid path",
+			converter: Converter{EmbeddedPath: "payload"},
+			errors:    []string{`invalid embedded path "payload", expected keys starting with ".", e.g. .payload`},
+		},
+		{
+			name:      "path with a missing key",
+			converter: Converter{EmbeddedPath: ".event..payload"},
+			errors:    []string{`invalid embedded path ".event..payload", a key is missing`},
+		},
+		{
+			name:      "textproto",
+			converter: Converter{EmbeddedPath: ".payload", Format: FormatTextProto},
+			errors:    []string{"json lines with embedded messages are json, they can't be converted to textproto"},
+		},
+		{
+			name:      "framing",
+			converter: Converter{EmbeddedPath: ".payload", Framing: FramingVarint},
+			errors:    []string{"json lines with embedded messages are one per line, they can't be combined with a framing or a marker"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			c := test.converter
+			c.Parser, c.Filename, c.MessageType = parser, filename, "Person"
+
+			// when
+			resultCh, errorCh := c.ConvertStream(strings.NewReader(test.input))
+
+			// then
+			results, errs := collect(resultCh, errorCh)
+			var resultStrings, errStrings []string
+			for _, res := range results {
+				resultStrings = append(resultStrings, string(res))
+			}
+			for _, err := range errs {
+				errStrings = append(errStrings, err.Error())
+			}
+			assert.Equal(t, test.results, resultStrings)
+			assert.Equal(t, test.errors, errStrings)
+		})
+	}
+}
+
+func Test_ConvertStream_KcatEnvelope(t *testing.T) {
+	payload, err := proto.Marshal(&another_tutorial.Person{Name: "A\"B\nC", Id: 200})
+	require.NoError(t, err)
+	key, err := proto.Marshal(&another_tutorial.Person_PhoneNumber{Number: "123"})
+	require.NoError(t, err)
+
+	parser, filename, err := protoparser.NewFile("../../testdata/addressbook.proto")
+	require.NoError(t, err)
+	keyDecoder, err := Converter{Parser: parser, Filename: filename, MessageType: "Person.PhoneNumber"}.NewDecoder()
+	require.NoError(t, err)
+
+	envelope := `{"topic":"people","partition":3,"offset":42,"tstype":"create","ts":1646218065015,"broker":1,` +
+		`"headers":["ce-type","person"],"key":` + kcatString(key) + `,"payload":` + kcatString(payload) + "}"
+	tombstone := `{"topic":"people","partition":3,"offset":43,"tstype":"create","ts":1646218065016,"broker":1,"key":null,"payload":null}`
+
+	tests := []struct {
+		name      string
+		converter Converter
+		input     string
+		results   []string
+		errors    []string
+	}{
+		{
+			name:      "payload",
+			converter: Converter{KcatEnvelope: true},
+			input:     envelope + "\n" + tombstone,
+			results: []string{
+				`{"topic":"people","partition":3,"offset":42,"tstype":"create","ts":1646218065015,"broker":1,` +
+					`"headers":["ce-type","person"],"key":` + kcatString(key) + `,"payload":{"name":"A\"B\nC","id":200}}`,
+				tombstone,
+			},
+		},
+		{
+			name:      "payload and key",
+			converter: Converter{KcatEnvelope: true, KeyDecoder: keyDecoder},
+			input:     envelope + "\n" + tombstone,
+			results: []string{
+				`{"topic":"people","partition":3,"offset":42,"tstype":"create","ts":1646218065015,"broker":1,` +
+					`"headers":["ce-type","person"],"key":{"number":"123"},"payload":{"name":"A\"B\nC","id":200}}`,
+				tombstone,
+			},
+		},
+		{
+			name:      "yaml",
+			converter: Converter{KcatEnvelope: true, Format: FormatYAML},
+			input:     `{"topic":"people","payload":` + kcatString(payload) + "}",
+			results:   []string{"topic: people\npayload:\n  name: |-\n    A\"B\n    C\n  id: 200"},
+		},
+		{
+			name:      "errors tell the line",
+			converter: Converter{KcatEnvelope: true, KeyDecoder: keyDecoder},
+			input:     `{"topic":"people"}` + "\n" + `{"payload":"\n"}` + "\n" + `{"key":"\n","payload":` + kcatString(payload) + "}",
+			errors: []string{
+				"line 1: there's no payload in the kcat envelope",
+				"line 2: decoding the payload: unexpected EOF",
+				"line 3: decoding the key: unexpected EOF",
+			},
+		},
+		{
+			name:      "embedded path",
+			converter: Converter{KcatEnvelope: true, EmbeddedPath: ".payload"},
+			errors:    []string{"kcat envelopes can't be combined with an embedded path or an encoding"},
+		},
+		{
+			name:      "textproto",
+			converter: Converter{KcatEnvelope: true, Format: FormatTextProto},
+			errors:    []string{"kcat envelopes are json, they can't be converted to textproto"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			c := test.converter
+			c.Parser, c.Filename, c.MessageType = parser, filename, "Person"
+
+			// when
+			resultCh, errorCh := c.ConvertStream(strings.NewReader(test.input))
+
+			// then
+			results, errs := collect(resultCh, errorCh)
+			var resultStrings, errStrings []string
+			for _, res := range results {
+				resultStrings = append(resultStrings, string(res))
+			}
+			for _, err := range errs {
+				errStrings = append(errStrings, err.Error())
+			}
+			assert.Equal(t, test.results, resultStrings)
+			assert.Equal(t, test.errors, errStrings)
+		})
+	}
+}
+
+func TestUnquoteBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []byte
+		err      string
+	}{
+		{name: "binary data as it is", input: "\"\xc8\x01\xff\"", expected: []byte{0xc8, 0x01, 0xff}},
+		{name: "escapes", input: `"\"\\\/\b\f\n\r\t\u0000\u001f"`, expected: []byte("\"\\/\b\f\n\r\t\x00\x1f")},
+		{name: "unicode escapes", input: `"\u00e9\ud83d\ude00"`, expected: []byte("é😀")},
+		{name: "lone surrogate", input: `"\ud83d"`, expected: []byte("\ufffd")},
+		{name: "not a string", input: `1`, err: "expected a json string"},
+		{name: "invalid escape", input: `"\x"`, err: `invalid escape \x in the json string`},
+		{name: "short unicode escape", input: `"\u12"`, err: `invalid \u escape in the json string`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := unquoteBytes([]byte(test.input))
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+// kcatString writes bytes as a json string the same way kcat does, as they are except for quotes, backslashes
+// and control characters.
+func kcatString(b []byte) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c < 0x20:
+			_, _ = fmt.Fprintf(&buf, `\u%04x`, c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// collect reads the results and the errors of a stream until both channels are closed.
+func collect(resultCh chan []byte, errorCh chan error) ([][]byte, []error) {
+	var results [][]byte
+	var errs []error
+	for resultCh != nil || errorCh != nil {
+		select {
+		case res, ok := <-resultCh:
+			if !ok {
+				resultCh = nil
+				continue
+			}
+			results = append(results, res)
+		case err, ok := <-errorCh:
+			if !ok {
+				errorCh = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return results, errs
+}
+
 func genAddressBook() *another_tutorial.AddressBook {
 	loc, _ := time.LoadLocation("UTC")
 	d := time.Date(2013, 1, 2, 9, 22, 0, 0, loc)
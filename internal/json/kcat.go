@@ -0,0 +1,142 @@
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/jhump/protoreflect/desc"
+)
+
+// convertKcat converts the payload, and the key if there's a key decoder, of a kcat -J envelope,
+// e.g. {"topic":"t","partition":0,"offset":1,"tstype":"create","ts":1646218065015,"broker":1,"key":"k","payload":"..."}.
+// The other fields of the envelope are passed through unchanged. Null payloads, i.e. tombstones, and null keys stay null.
+func (c Converter) convertKcat(md *desc.MessageDescriptor, line []byte) ([]byte, error) {
+	res, found, err := replaceAt(bytes.TrimSpace(line), []string{"payload"}, func(value stdjson.RawMessage) (stdjson.RawMessage, error) {
+		if string(value) == "null" {
+			return value, nil
+		}
+
+		rawMessage, err := unquoteBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding the payload: %w", err)
+		}
+		res, err := c.embeddedJSON(md, rawMessage)
+		if err != nil {
+			return nil, fmt.Errorf("decoding the payload: %w", err)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("there's no payload in the kcat envelope")
+	}
+
+	if c.KeyDecoder != nil {
+		res, _, err = replaceAt(res, []string{"key"}, func(value stdjson.RawMessage) (stdjson.RawMessage, error) {
+			if string(value) == "null" {
+				return value, nil
+			}
+
+			rawKey, err := unquoteBytes(value)
+			if err != nil {
+				return nil, fmt.Errorf("decoding the key: %w", err)
+			}
+			res, err := c.KeyDecoder.Decode(rawKey)
+			if err != nil {
+				return nil, fmt.Errorf("decoding the key: %w", err)
+			}
+			return stdjson.RawMessage(res), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.formatLine(res)
+}
+
+// unquoteBytes returns the bytes of a json string as they are. kcat writes binary data as it is in json strings,
+// escaping only quotes, backslashes and control characters, so unlike encoding/json, invalid UTF-8 isn't replaced.
+func unquoteBytes(s []byte) ([]byte, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return nil, errors.New("expected a json string")
+	}
+	s = s[1 : len(s)-1]
+
+	res := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			res = append(res, s[i])
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			return nil, errors.New("invalid escape at the end of the json string")
+		}
+		switch s[i] {
+		case '"', '\\', '/':
+			res = append(res, s[i])
+		case 'b':
+			res = append(res, '\b')
+		case 'f':
+			res = append(res, '\f')
+		case 'n':
+			res = append(res, '\n')
+		case 'r':
+			res = append(res, '\r')
+		case 't':
+			res = append(res, '\t')
+		case 'u':
+			r, n, err := unquoteRune(s[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			i += n
+			var buf [utf8.UTFMax]byte
+			res = append(res, buf[:utf8.EncodeRune(buf[:], r)]...)
+		default:
+			return nil, fmt.Errorf("invalid escape \\%c in the json string", s[i])
+		}
+	}
+	return res, nil
+}
+
+// unquoteRune reads the hex digits of a \u escape, along with the low surrogate of a surrogate pair,
+// and returns the rune and the number of bytes read.
+func unquoteRune(s []byte) (rune, int, error) {
+	r, err := hexRune(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, 4, nil
+	}
+
+	if len(s) >= 10 && s[4] == '\\' && s[5] == 'u' {
+		if low, err := hexRune(s[6:]); err == nil {
+			if pair := utf16.DecodeRune(r, low); pair != utf8.RuneError {
+				return pair, 10, nil
+			}
+		}
+	}
+	return utf8.RuneError, 4, nil
+}
+
+func hexRune(s []byte) (rune, error) {
+	if len(s) < 4 {
+		return 0, errors.New("invalid \\u escape in the json string")
+	}
+	r, err := strconv.ParseUint(string(s[:4]), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid \\u escape in the json string: %w", err)
+	}
+	return rune(r), nil
+}
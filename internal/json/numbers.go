@@ -0,0 +1,172 @@
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// int64AsNumbers rewrites the 64-bit integers of a json message, which are strings in protobuf json, as numbers.
+// The message descriptor tells which strings are 64-bit integers, and the order of the fields is kept.
+// Values of types that aren't known from the descriptor, like google.protobuf.Any, are left as they are.
+func int64AsNumbers(b []byte, md *desc.MessageDescriptor) ([]byte, error) {
+	d := stdjson.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var buf bytes.Buffer
+	if err := writeValue(d, &buf, md, nil, false); err != nil {
+		return nil, fmt.Errorf("rewriting 64-bit integers as numbers: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeValue copies the next json value from the decoder to the buffer. The value is either a message of the md type,
+// or the value of the fd field, or one of its elements if elem is set. Both are nil for values of unknown types.
+func writeValue(d *stdjson.Decoder, buf *bytes.Buffer, md *desc.MessageDescriptor, fd *desc.FieldDescriptor, elem bool) error {
+	if fd != nil && (!fd.IsRepeated() || elem) {
+		md = fd.GetMessageType()
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case stdjson.Delim:
+		switch tok {
+		case '{':
+			return writeObject(d, buf, md, fd, elem)
+		case '[':
+			return writeArray(d, buf, fd)
+		default:
+			return fmt.Errorf("unexpected %s", tok)
+		}
+	case string:
+		if isInt64(md, fd) {
+			if _, err := strconv.ParseInt(tok, 10, 64); err == nil {
+				buf.WriteString(tok)
+				return nil
+			}
+			if _, err := strconv.ParseUint(tok, 10, 64); err == nil {
+				buf.WriteString(tok)
+				return nil
+			}
+		}
+		return writeJSON(buf, tok)
+	case stdjson.Number:
+		buf.WriteString(tok.String())
+		return nil
+	default:
+		return writeJSON(buf, tok)
+	}
+}
+
+// writeObject copies an object, either a message or a map, whose opening brace has been read already.
+func writeObject(d *stdjson.Decoder, buf *bytes.Buffer, md *desc.MessageDescriptor, fd *desc.FieldDescriptor, elem bool) error {
+	isMap := fd != nil && fd.IsMap() && !elem
+
+	buf.WriteByte('{')
+	for i := 0; d.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected key %v", tok)
+		}
+		if err := writeJSON(buf, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+
+		if isMap {
+			err = writeValue(d, buf, nil, fd.GetMapValueType(), false)
+		} else {
+			err = writeValue(d, buf, nil, findField(md, key), false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+
+	_, err := d.Token()
+	return err
+}
+
+// writeArray copies the elements of a repeated field, whose opening bracket has been read already.
+func writeArray(d *stdjson.Decoder, buf *bytes.Buffer, fd *desc.FieldDescriptor) error {
+	if fd != nil && !fd.IsRepeated() {
+		// e.g. a google.protobuf.ListValue
+		fd = nil
+	}
+
+	buf.WriteByte('[')
+	for i := 0; d.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeValue(d, buf, nil, fd, true); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+
+	_, err := d.Token()
+	return err
+}
+
+// findField returns the field of the message with the given json or proto name, or nil if there's none.
+func findField(md *desc.MessageDescriptor, name string) *desc.FieldDescriptor {
+	if md == nil {
+		return nil
+	}
+	for _, fd := range md.GetFields() {
+		if fd.GetJSONName() == name || fd.GetName() == name {
+			return fd
+		}
+	}
+	return nil
+}
+
+// isInt64 tells whether the value is a 64-bit integer, either a field or a wrapper message.
+func isInt64(md *desc.MessageDescriptor, fd *desc.FieldDescriptor) bool {
+	if md != nil {
+		switch md.GetFullyQualifiedName() {
+		case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+			return true
+		}
+		return false
+	}
+	if fd == nil {
+		return false
+	}
+
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	b, err := stdjson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
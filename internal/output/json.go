@@ -0,0 +1,132 @@
+package output
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MsgError is an error about a single consumed message, e.g. a decoding error,
+// along with the message metadata and its value as consumed.
+type MsgError struct {
+	Msg Msg
+	Raw []byte
+	Err error
+}
+
+// Error returns the underlying error, so that printers without structured output print errors as they are.
+func (e *MsgError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *MsgError) Unwrap() error {
+	return e.Err
+}
+
+// JSONPrinter is a printer that prints each message as a json envelope on its own line, i.e. NDJSON.
+type JSONPrinter struct {
+	out, errOut io.Writer
+}
+
+// envelope is a message along with its metadata. The value is embedded as json if it's json,
+// otherwise it's a string. Message errors have the error and the raw value encoded as base64 instead of the value.
+type envelope struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int             `json:"offset"`
+	Timestamp int64           `json:"timestamp"`
+	Key       *string         `json:"key"`
+	Headers   []jsonHeader    `json:"headers"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Raw       *string         `json:"raw,omitempty"`
+}
+
+type jsonHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// NewJSONPrinter returns a new instance of a printer that prints NDJSON envelopes.
+// Message errors are printed as envelopes to the output too, so that they are part of the stream.
+func NewJSONPrinter(out, errOut io.Writer) *JSONPrinter {
+	return &JSONPrinter{
+		out:    out,
+		errOut: errOut,
+	}
+}
+
+// Print prints the message envelope.
+func (j *JSONPrinter) Print(msg Msg) {
+	e := newEnvelope(msg)
+	e.Value = jsonValue(msg.Value)
+
+	j.write(j.out, e)
+}
+
+// PrintErr prints message errors as envelopes with the error and the raw value, and other errors as json objects.
+func (j *JSONPrinter) PrintErr(err error) {
+	var msgErr *MsgError
+	if !errors.As(err, &msgErr) {
+		j.write(j.errOut, struct {
+			Error string `json:"error"`
+		}{Error: err.Error()})
+		return
+	}
+
+	e := newEnvelope(msgErr.Msg)
+	e.Error = msgErr.Err.Error()
+	if msgErr.Raw != nil {
+		raw := base64.StdEncoding.EncodeToString(msgErr.Raw)
+		e.Raw = &raw
+	}
+
+	j.write(j.out, e)
+}
+
+func (j *JSONPrinter) write(w io.Writer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		_, _ = fmt.Fprintln(j.errOut, err)
+		return
+	}
+
+	// a single write, so that lines of concurrently printed messages don't interleave
+	_, _ = w.Write(append(b, '\n'))
+}
+
+func newEnvelope(msg Msg) envelope {
+	e := envelope{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Time.UnixMilli(),
+		Headers:   make([]jsonHeader, 0, len(msg.Headers)),
+	}
+
+	if msg.KeySize >= 0 {
+		key := msg.Key
+		e.Key = &key
+	}
+
+	for _, h := range msg.Headers {
+		e.Headers = append(e.Headers, jsonHeader{Key: h.Key, Value: h.Value})
+	}
+
+	return e
+}
+
+// jsonValue returns the value as compact json if it's json, or as a json string otherwise.
+func jsonValue(value string) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(value)); err == nil {
+		return buf.Bytes()
+	}
+
+	b, _ := json.Marshal(value)
+	return b
+}
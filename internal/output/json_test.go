@@ -0,0 +1,108 @@
+package output
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPrinter_Print(t *testing.T) {
+	currentTime := time.Unix(42, 123)
+
+	tests := []struct {
+		name     string
+		msg      Msg
+		expected string
+	}{
+		{
+			name: "value embedded as json",
+			msg: Msg{
+				Key:       "my-key",
+				Value:     "{\n  \"name\": \"ABC\",\n  \"id\": 1\n}",
+				KeySize:   6,
+				Topic:     "my-topic",
+				Partition: 3,
+				Offset:    42,
+				Time:      currentTime,
+				Headers:   []Header{{Key: "ce-type", Value: "order.created"}, {Key: "ce-type", Value: "order.updated"}},
+			},
+			expected: `{"topic":"my-topic","partition":3,"offset":42,"timestamp":42000,"key":"my-key",` +
+				`"headers":[{"key":"ce-type","value":"order.created"},{"key":"ce-type","value":"order.updated"}],` +
+				`"value":{"name":"ABC","id":1}}` + "\n",
+		},
+		{
+			name:     "value that isn't json and null key",
+			msg:      Msg{Value: `plain "text"`, KeySize: -1, Topic: "my-topic", Time: currentTime},
+			expected: `{"topic":"my-topic","partition":0,"offset":0,"timestamp":42000,"key":null,"headers":[],"value":"plain \"text\""}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			buffer := bytes.NewBufferString("")
+			bufferErr := bytes.NewBufferString("")
+			printer := NewJSONPrinter(buffer, bufferErr)
+
+			// when
+			printer.Print(test.msg)
+
+			// then
+			assert.Equal(t, test.expected, buffer.String())
+			assert.Empty(t, bufferErr.String())
+		})
+	}
+}
+
+func TestJSONPrinter_PrintErr(t *testing.T) {
+	currentTime := time.Unix(42, 123)
+
+	tests := []struct {
+		name        string
+		err         error
+		expected    string
+		expectedErr string
+	}{
+		{
+			name: "message error with the raw value",
+			err: fmt.Errorf("wrapped: %w", &MsgError{
+				Msg: Msg{Key: "my-key", KeySize: 6, Topic: "my-topic", Partition: 3, Offset: 42, Time: currentTime},
+				Raw: []byte{0x0a, 0xff},
+				Err: errors.New("unexpected EOF"),
+			}),
+			expected: `{"topic":"my-topic","partition":3,"offset":42,"timestamp":42000,"key":"my-key","headers":[],` +
+				`"error":"unexpected EOF","raw":"Cv8="}` + "\n",
+		},
+		{
+			name:        "other error",
+			err:         errors.New("b00m"),
+			expectedErr: `{"error":"b00m"}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			buffer := bytes.NewBufferString("")
+			bufferErr := bytes.NewBufferString("")
+			printer := NewJSONPrinter(buffer, bufferErr)
+
+			// when
+			printer.PrintErr(test.err)
+
+			// then
+			assert.Equal(t, test.expected, buffer.String())
+			assert.Equal(t, test.expectedErr, bufferErr.String())
+		})
+	}
+}
+
+func TestMsgError(t *testing.T) {
+	err := errors.New("b00m")
+	msgErr := &MsgError{Err: err}
+
+	assert.EqualError(t, msgErr, "b00m")
+	assert.ErrorIs(t, msgErr, err)
+}
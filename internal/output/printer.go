@@ -15,57 +15,217 @@ type Printer interface {
 }
 
 // Msg is the successfully consumed Kafka message with some metadata for it.
+// KeySize and ValueSize are the sizes in bytes of the consumed key and value before decoding, -1 if they are null.
 type Msg struct {
-	Key, Value        string
-	Partition, Offset int
-	Topic             string
-	Time              time.Time
+	Key, Value         string
+	KeySize, ValueSize int
+	Partition, Offset  int
+	Topic              string
+	Time               time.Time
+	Headers            []Header
+}
+
+// Header is a Kafka record header. Headers keep the order they were produced in, and a key may appear more than once.
+type Header struct {
+	Key, Value string
+}
+
+type tokenKind int
+
+const (
+	literalToken tokenKind = iota
+	valueToken
+	keyToken
+	topicToken
+	partitionToken
+	offsetToken
+	timestampToken
+	timeToken
+	headersToken
+	headerToken
+	keySizeToken
+	valueSizeToken
+)
+
+// token is a part of a format string: either literal text, or a field of the message.
+// The text is the literal text, or the name of the header for header tokens.
+type token struct {
+	kind tokenKind
+	text string
 }
 
 // FormattedPrinter is a printer that knows how to parse the Kafkacat's format spec.
 type FormattedPrinter struct {
-	format      string
+	tokens      []token
 	out, errOut io.Writer
 }
 
 // NewFormatterPrinter returns a new instance of a printer that supports formatting similar to kafkacat's.
+// The format is parsed once, so that the message fields are never parsed as format tokens themselves.
 // Format tokens:
-// 	%s		Message payload
+//
+//	%s		Message payload
+//	%S		Message payload size in bytes, -1 if null
 //	%k		Message key
+//	%K		Message key size in bytes, -1 if null
 //	%t		Topic
 //	%p		Partition
 //	%o		Offset
 //	%T		Timestamp in milliseconds
 //	%Tf		Timestamp formatted as RFC3339
-//  \n \r 	Newlines
-// 	\t		Tab
+//	%h		All headers as name=value pairs separated by commas
+//	%h{name}	Value of the named header
+//	%%		A percent sign
+//	\n \r		Newlines
+//	\t		Tab
+//
+// Any other % or \ sequence is printed as it is.
 func NewFormatterPrinter(format string, out, errOut io.Writer) *FormattedPrinter {
 	return &FormattedPrinter{
-		format: format,
+		tokens: parseFormat(format),
 		out:    out,
 		errOut: errOut,
 	}
 }
 
+// parseFormat splits the format into tokens, merging adjacent literal text.
+func parseFormat(format string) []token {
+	var (
+		tokens  []token
+		literal strings.Builder
+	)
+	add := func(kind tokenKind, text string) {
+		if literal.Len() > 0 {
+			tokens = append(tokens, token{kind: literalToken, text: literal.String()})
+			literal.Reset()
+		}
+		tokens = append(tokens, token{kind: kind, text: text})
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if i == len(format)-1 || (c != '%' && c != '\\') {
+			literal.WriteByte(c)
+			continue
+		}
+
+		next := format[i+1]
+		if c == '\\' {
+			switch next {
+			case 'n':
+				literal.WriteByte('\n')
+			case 'r':
+				literal.WriteByte('\r')
+			case 't':
+				literal.WriteByte('\t')
+			default:
+				literal.WriteByte(c)
+				continue
+			}
+			i++
+			continue
+		}
+
+		i++
+		switch next {
+		case '%':
+			literal.WriteByte('%')
+		case 's':
+			add(valueToken, "")
+		case 'S':
+			add(valueSizeToken, "")
+		case 'k':
+			add(keyToken, "")
+		case 'K':
+			add(keySizeToken, "")
+		case 't':
+			add(topicToken, "")
+		case 'p':
+			add(partitionToken, "")
+		case 'o':
+			add(offsetToken, "")
+		case 'T':
+			if i+1 < len(format) && format[i+1] == 'f' {
+				i++
+				add(timeToken, "")
+			} else {
+				add(timestampToken, "")
+			}
+		case 'h':
+			end := strings.IndexByte(format[i+1:], '}')
+			if i+1 < len(format) && format[i+1] == '{' && end > 0 {
+				add(headerToken, format[i+2:i+1+end])
+				i += 1 + end
+			} else {
+				add(headersToken, "")
+			}
+		default:
+			literal.WriteByte(c)
+			i--
+		}
+	}
+
+	if literal.Len() > 0 {
+		tokens = append(tokens, token{kind: literalToken, text: literal.String()})
+	}
+	return tokens
+}
+
 // Print applies a specific format to a consumed Kafka message.
 func (f *FormattedPrinter) Print(msg Msg) {
-	val := f.format
-
-	val = strings.ReplaceAll(val, "\\t", "\t")
-	val = strings.ReplaceAll(val, "\\n", "\n")
-	val = strings.ReplaceAll(val, "\\r", "\r")
-	val = strings.ReplaceAll(val, "%s", msg.Value)
-	val = strings.ReplaceAll(val, "%k", msg.Key)
-	val = strings.ReplaceAll(val, "%t", msg.Topic)
-	val = strings.ReplaceAll(val, "%p", fmt.Sprintf("%d", msg.Partition))
-	val = strings.ReplaceAll(val, "%o", fmt.Sprintf("%d", msg.Offset))
-	val = strings.ReplaceAll(val, "%Tf", msg.Time.Format(time.RFC3339))
-	val = strings.ReplaceAll(val, "%T", strconv.Itoa(int(msg.Time.UnixMilli())))
-
-	_, _ = fmt.Fprintln(f.out, val)
+	var b strings.Builder
+	for _, t := range f.tokens {
+		switch t.kind {
+		case literalToken:
+			b.WriteString(t.text)
+		case valueToken:
+			b.WriteString(msg.Value)
+		case valueSizeToken:
+			b.WriteString(strconv.Itoa(msg.ValueSize))
+		case keyToken:
+			b.WriteString(msg.Key)
+		case keySizeToken:
+			b.WriteString(strconv.Itoa(msg.KeySize))
+		case topicToken:
+			b.WriteString(msg.Topic)
+		case partitionToken:
+			b.WriteString(strconv.Itoa(msg.Partition))
+		case offsetToken:
+			b.WriteString(strconv.Itoa(msg.Offset))
+		case timestampToken:
+			b.WriteString(strconv.FormatInt(msg.Time.UnixMilli(), 10))
+		case timeToken:
+			b.WriteString(msg.Time.Format(time.RFC3339))
+		case headersToken:
+			b.WriteString(formatHeaders(msg.Headers))
+		case headerToken:
+			b.WriteString(headerValue(msg.Headers, t.text))
+		}
+	}
+
+	_, _ = fmt.Fprintln(f.out, b.String())
 }
 
 // PrintErr knows how to print an error.
 func (f *FormattedPrinter) PrintErr(err error) {
 	_, _ = fmt.Fprintln(f.errOut, err)
 }
+
+// headerValue returns the value of the first header with the given key, or an empty string if there's none.
+func headerValue(headers []Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// formatHeaders formats the headers the way kafkacat does, e.g. trace-id=abc,ce-type=order.created
+func formatHeaders(headers []Header) string {
+	pairs := make([]string, 0, len(headers))
+	for _, h := range headers {
+		pairs = append(pairs, h.Key+"="+h.Value)
+	}
+	return strings.Join(pairs, ",")
+}
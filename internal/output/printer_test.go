@@ -31,6 +31,89 @@ func TestPrint(t *testing.T) {
 			},
 			expected: fmt.Sprintf("Topic: my-topic, Key: my-key, \n\rMsg: my-val, \tTimestamp: 42000, Time: %s\n", timeFormatted),
 		},
+		{
+			name:   "headers",
+			format: "%h | %h{ce-type} | %h{missing} | %s",
+			msg: Msg{
+				Value: "my-val",
+				Headers: []Header{
+					{Key: "trace-id", Value: "abc"},
+					{Key: "ce-type", Value: "order.created"},
+					{Key: "ce-type", Value: "order.updated"},
+				},
+			},
+			expected: "trace-id=abc,ce-type=order.created,ce-type=order.updated | order.created |  | my-val\n",
+		},
+		{
+			name:   "payload with format tokens isn't formatted again",
+			format: "%s|%k|%t",
+			msg: Msg{
+				Key:   `%s %t \n`,
+				Value: `{"note":"%k %t %T %Tf %p %o %h %h{x} %% \t"}`,
+				Topic: "%s",
+			},
+			expected: `{"note":"%k %t %T %Tf %p %o %h %h{x} %% \t"}|%s %t \n|%s` + "\n",
+		},
+		{
+			name:     "header values with format tokens aren't formatted again",
+			format:   "%h{a} %h",
+			msg:      Msg{Value: "v", Headers: []Header{{Key: "a", Value: "%s"}, {Key: "%k", Value: "%Tf"}}},
+			expected: "%s a=%s,%k=%Tf\n",
+		},
+		{
+			name:     "percent escapes",
+			format:   "100%% %%s %%%s",
+			msg:      Msg{Value: "v"},
+			expected: "100% %s %v\n",
+		},
+		{
+			name:     "sizes",
+			format:   "%K %S %k",
+			msg:      Msg{Key: "", KeySize: -1, Value: `{"a":1}`, ValueSize: 3},
+			expected: "-1 3 \n",
+		},
+		{
+			name:     "partition and offset",
+			format:   "%t [%p] @%o",
+			msg:      Msg{Topic: "t", Partition: 3, Offset: 42},
+			expected: "t [3] @42\n",
+		},
+		{
+			name:     "unknown tokens and escapes are kept",
+			format:   `%x %Q \x \\ 50%`,
+			msg:      Msg{Value: "v"},
+			expected: `%x %Q \x \\ 50%` + "\n",
+		},
+		{
+			name:     "trailing escape characters",
+			format:   `%s\`,
+			msg:      Msg{Value: "v"},
+			expected: `v\` + "\n",
+		},
+		{
+			name:     "unclosed header name is all headers",
+			format:   "%h{a",
+			msg:      Msg{Headers: []Header{{Key: "a", Value: "1"}}},
+			expected: "a=1{a\n",
+		},
+		{
+			name:     "time tokens next to each other",
+			format:   "%T%Tf%TT",
+			msg:      Msg{Time: currentTime},
+			expected: fmt.Sprintf("42000%s42000T\n", timeFormatted),
+		},
+		{
+			name:     "empty format",
+			format:   "",
+			msg:      Msg{Value: "v"},
+			expected: "\n",
+		},
+		{
+			name:     "no headers",
+			format:   "[%h] %s",
+			msg:      Msg{Value: "my-val"},
+			expected: "[] my-val\n",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
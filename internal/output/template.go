@@ -0,0 +1,185 @@
+package output
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplatePrinter is a printer that prints messages with a Go text/template.
+type TemplatePrinter struct {
+	tmpl        *template.Template
+	out, errOut io.Writer
+}
+
+// templateData is what templates are executed with. Value is the decoded message as a map,
+// or as a string if it isn't a json object, and Headers holds the first value of each header.
+type templateData struct {
+	Key               string
+	Value             interface{}
+	Topic             string
+	Partition, Offset int
+	Time              time.Time
+	Headers           map[string]string
+}
+
+// NewTemplatePrinter returns a new instance of a printer that executes the given Go text/template for each message.
+// Templates can use .Key, .Value, .Topic, .Partition, .Offset, .Time and .Headers, and these functions:
+//
+//	json		Value as compact json
+//	toYaml		Value as yaml
+//	date		Time, RFC3339 string or milliseconds since epoch formatted with a Go time layout, e.g. {{.Time | date "15:04"}}
+//	base64		String encoded as base64
+//	base64Decode	Base64 string decoded, e.g. a bytes field of the message
+func NewTemplatePrinter(text string, out, errOut io.Writer) (*TemplatePrinter, error) {
+	tmpl, err := template.New("message").Funcs(template.FuncMap{
+		"json":         toJSON,
+		"toYaml":       toYAML,
+		"date":         date,
+		"base64":       encodeBase64,
+		"base64Decode": decodeBase64,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+
+	return &TemplatePrinter{
+		tmpl:   tmpl,
+		out:    out,
+		errOut: errOut,
+	}, nil
+}
+
+// Print executes the template with a consumed Kafka message.
+func (t *TemplatePrinter) Print(msg Msg) {
+	data := templateData{
+		Key:       msg.Key,
+		Value:     decodeValue(msg.Value),
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      msg.Time,
+		Headers:   map[string]string{},
+	}
+	for _, h := range msg.Headers {
+		if _, ok := data.Headers[h.Key]; !ok {
+			data.Headers[h.Key] = h.Value
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		t.PrintErr(fmt.Errorf("executing the template for %s [%d] at offset %d: %w", msg.Topic, msg.Partition, msg.Offset, err))
+		return
+	}
+
+	_, _ = fmt.Fprintln(t.out, buf.String())
+}
+
+// PrintErr knows how to print an error.
+func (t *TemplatePrinter) PrintErr(err error) {
+	_, _ = fmt.Fprintln(t.errOut, err)
+}
+
+// decodeValue decodes a json message, keeping numbers as they are, e.g. 64-bit integers.
+func decodeValue(value string) interface{} {
+	d := json.NewDecoder(strings.NewReader(value))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return value
+	}
+	return v
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(yamlValue(v))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// yamlValue replaces json numbers with plain numbers, so that they aren't quoted as strings in yaml.
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			res[k] = yamlValue(value)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, value := range v {
+			res[i] = yamlValue(value)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// date formats a time with the layout. Times can also be RFC3339 strings, like timestamps of protobuf json,
+// or milliseconds since epoch.
+func date(layout string, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(layout), nil
+	case json.Number:
+		ms, err := strconv.ParseInt(v.String(), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s isn't a time in milliseconds", v)
+		}
+		return time.UnixMilli(ms).UTC().Format(layout), nil
+	case int:
+		return time.UnixMilli(int64(v)).UTC().Format(layout), nil
+	case int64:
+		return time.UnixMilli(v).UTC().Format(layout), nil
+	default:
+		return "", fmt.Errorf("can't format %v of type %T as a date", v, v)
+	}
+}
+
+func encodeBase64(v interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v)))
+}
+
+func decodeBase64(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,148 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/beatlabs/proton/v2/internal/json"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const orderSchema = `syntax = "proto3";
+package shop.v1;
+
+import "google/protobuf/timestamp.proto";
+
+message Order {
+  string order_id = 1;
+  Money total = 2;
+  google.protobuf.Timestamp created = 3;
+  repeated string tags = 4;
+  bytes blob = 5;
+}
+
+message Money {
+  string currency = 1;
+  int64 units = 2;
+}`
+
+func TestTemplatePrinter_Print(t *testing.T) {
+	msg := Msg{
+		Key:       "my-key",
+		Value:     decodeOrder(t, `{"order_id":"o-1","total":{"units":"9007199254740993","currency":"EUR"},"created":"2022-03-02T10:04:05Z","tags":["a","b"],"blob":"aGVsbG8="}`),
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Time:      time.Date(2022, 3, 2, 10, 0, 0, 0, time.UTC),
+		Headers:   []Header{{Key: "ce-type", Value: "order.created"}, {Key: "ce-type", Value: "ignored"}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		msg      Msg
+		expected string
+		err      string
+	}{
+		{
+			name:     "message fields",
+			template: `{{.Value.orderId}} {{.Time | date "15:04"}}`,
+			msg:      msg,
+			expected: "o-1 10:00\n",
+		},
+		{
+			name:     "metadata",
+			template: `{{.Topic}}/{{.Partition}}@{{.Offset}} {{.Key}} {{index .Headers "ce-type"}}`,
+			msg:      msg,
+			expected: "orders/3@42 my-key order.created\n",
+		},
+		{
+			name:     "json",
+			template: `{{json .Value.total}}`,
+			msg:      msg,
+			expected: `{"currency":"EUR","units":"9007199254740993"}` + "\n",
+		},
+		{
+			name:     "yaml",
+			template: `{{toYaml .Value.total}}`,
+			msg:      msg,
+			expected: "currency: EUR\nunits: \"9007199254740993\"\n",
+		},
+		{
+			name:     "date of a message field",
+			template: `{{.Value.created | date "2006-01-02 15:04"}}`,
+			msg:      msg,
+			expected: "2022-03-02 10:04\n",
+		},
+		{
+			name:     "base64",
+			template: `{{base64 .Key}} {{base64Decode .Value.blob}}`,
+			msg:      msg,
+			expected: "bXkta2V5 hello\n",
+		},
+		{
+			name:     "range",
+			template: `{{range .Value.tags}}{{.}};{{end}}`,
+			msg:      msg,
+			expected: "a;b;\n",
+		},
+		{
+			name:     "value that isn't json",
+			template: `{{.Value}}`,
+			msg:      Msg{Value: "plain"},
+			expected: "plain\n",
+		},
+		{
+			name:     "execution error",
+			template: `{{.Value.orderId | date "15:04"}}`,
+			msg:      msg,
+			err:      `executing the template for orders [3] at offset 42: template: message:1:19: executing "message" at <date "15:04">: error calling date: parsing time "o-1" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "o-1" as "2006"` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			buffer := bytes.NewBufferString("")
+			bufferErr := bytes.NewBufferString("")
+			printer, err := NewTemplatePrinter(test.template, buffer, bufferErr)
+			require.NoError(t, err)
+
+			// when
+			printer.Print(test.msg)
+
+			// then
+			assert.Equal(t, test.expected, buffer.String())
+			assert.Equal(t, test.err, bufferErr.String())
+		})
+	}
+}
+
+func TestNewTemplatePrinter_Invalid(t *testing.T) {
+	_, err := NewTemplatePrinter("{{.Value", nil, nil)
+	assert.EqualError(t, err, `invalid template: template: message:1: unclosed action`)
+}
+
+// decodeOrder returns an order, given as json, the way the consumer decodes it, so that templates see the json names.
+func decodeOrder(t *testing.T, order string) string {
+	t.Helper()
+
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(map[string]string{"order.proto": orderSchema})}
+	files, err := parser.ParseFiles("order.proto")
+	require.NoError(t, err)
+
+	msg := dynamic.NewMessage(files[0].FindMessage("shop.v1.Order"))
+	require.NoError(t, msg.UnmarshalJSON([]byte(order)))
+	raw, err := msg.Marshal()
+	require.NoError(t, err)
+
+	decoder, err := json.Converter{Parser: parser, Filename: "order.proto", Package: "shop.v1", MessageType: "Order"}.NewDecoder()
+	require.NoError(t, err)
+
+	res, err := decoder.Decode(raw)
+	require.NoError(t, err)
+	return res
+}
@@ -0,0 +1,258 @@
+package registry
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/beatlabs/proton/v2/internal/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	orderSchema = `syntax = "proto3";
+package shop.v1;
+
+import "common/money.proto";
+
+message Order {
+  string id = 1;
+  common.Money total = 2;
+
+  message Line {
+    string sku = 1;
+  }
+}
+
+message Refund {
+  string order_id = 1;
+}`
+
+	moneySchema = `syntax = "proto3";
+package common;
+
+message Money {
+  string currency = 1;
+  int32 units = 2;
+}`
+)
+
+// newRegistry returns a stand-in schema registry with the order schema as ID 7, and the number of requests per path.
+// ID 8 is a schema of another type, and ID 10 is unavailable.
+func newRegistry(t *testing.T) (*httptest.Server, func(string) int) {
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schemas/ids/7", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{
+			"schemaType": "PROTOBUF",
+			"schema": ` + quote(orderSchema) + `,
+			"references": [{"name": "common/money.proto", "subject": "money-value", "version": 3}]
+		}`))
+	})
+	mux.HandleFunc("/subjects/money-value/versions/3", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"subject": "money-value", "version": 3, "id": 5, "schemaType": "PROTOBUF", "schema": ` + quote(moneySchema) + `}`))
+	})
+	mux.HandleFunc("/schemas/ids/8", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"schema": "{\"type\": \"string\"}"}`))
+	})
+	mux.HandleFunc("/schemas/ids/10", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error_code": 40403, "message": "Schema not found"}`))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+}
+
+func quote(s string) string {
+	b, _ := stdjson.Marshal(s)
+	return string(b)
+}
+
+// wireFormat frames a protobuf message the way Confluent serializers do.
+func wireFormat(id uint32, indexes []int64, msg []byte) []byte {
+	b := []byte{magicByte, byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	if len(indexes) == 1 && indexes[0] == 0 {
+		b = append(b, 0)
+	} else {
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(len(indexes))))
+		for _, i := range indexes {
+			b = protowire.AppendVarint(b, protowire.EncodeZigZag(i))
+		}
+	}
+	return append(b, msg...)
+}
+
+func TestDecoder_Decode(t *testing.T) {
+	order := protowire.AppendTag(nil, 1, protowire.BytesType)
+	order = protowire.AppendString(order, "o-1")
+	money := protowire.AppendTag(nil, 1, protowire.BytesType)
+	money = protowire.AppendString(money, "EUR")
+	money = protowire.AppendTag(money, 2, protowire.VarintType)
+	money = protowire.AppendVarint(money, 5)
+	order = protowire.AppendTag(order, 2, protowire.BytesType)
+	order = protowire.AppendBytes(order, money)
+
+	sku := protowire.AppendTag(nil, 1, protowire.BytesType)
+	sku = protowire.AppendString(sku, "sku-1")
+
+	tests := []struct {
+		name     string
+		input    []byte
+		expected string
+		err      string
+	}{
+		{
+			name:     "first message type with a referenced schema",
+			input:    wireFormat(7, []int64{0}, order),
+			expected: `{"id":"o-1","total":{"currency":"EUR","units":5}}`,
+		},
+		{
+			name:     "second message type",
+			input:    wireFormat(7, []int64{1}, sku),
+			expected: `{"orderId":"sku-1"}`,
+		},
+		{
+			name:     "nested message type",
+			input:    wireFormat(7, []int64{0, 0}, sku),
+			expected: `{"sku":"sku-1"}`,
+		},
+		{
+			name:  "message index out of range",
+			input: wireFormat(7, []int64{2}, sku),
+			err:   "schema 7: no message type at indexes [2]",
+		},
+		{
+			name:  "unknown schema",
+			input: wireFormat(9, []int64{0}, order),
+			err:   "fetching /schemas/ids/9 from the schema registry: status code is 404: Schema not found",
+		},
+		{
+			name:  "not a protobuf schema",
+			input: wireFormat(8, []int64{0}, order),
+			err:   "schema 8 isn't a protobuf schema",
+		},
+		{
+			name:  "unknown magic byte",
+			input: append([]byte{1, 0, 0, 0, 7, 0}, order...),
+			err:   "unknown magic byte 1, expected 0 of the schema registry wire format",
+		},
+		{
+			name:  "too short",
+			input: []byte{0, 0, 7},
+			err:   "message is too short for the schema registry wire format",
+		},
+		{
+			name:  "more indexes than bytes",
+			input: []byte{0, 0, 0, 0, 7, 20, 0},
+			err:   "invalid number of message indexes 10 in the schema registry wire format",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			server, _ := newRegistry(t)
+			decoder := NewDecoder(NewClient(context.Background(), server.URL), json.Converter{})
+
+			// when
+			res, err := decoder.Decode(test.input)
+
+			// then
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, test.expected, res)
+		})
+	}
+}
+
+func TestDecoder_DecodeCachesSchemas(t *testing.T) {
+	// given
+	server, requests := newRegistry(t)
+	decoder := NewDecoder(NewClient(context.Background(), server.URL+"/"), json.Converter{})
+
+	// when
+	for _, indexes := range [][]int64{{0}, {1}, {0}, {0, 0}} {
+		_, err := decoder.Decode(wireFormat(7, indexes, nil))
+		require.NoError(t, err)
+	}
+
+	// then
+	assert.Equal(t, 1, requests("/schemas/ids/7"))
+	assert.Equal(t, 1, requests("/subjects/money-value/versions/3"))
+}
+
+func TestDecoder_DecodeCachesFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       uint32
+		path     string
+		requests int
+	}{
+		{name: "unknown schema", id: 9, path: "/schemas/ids/9", requests: 1},
+		{name: "not a protobuf schema", id: 8, path: "/schemas/ids/8", requests: 1},
+		{name: "unavailable registry", id: 10, path: "/schemas/ids/10", requests: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			server, requests := newRegistry(t)
+			decoder := NewDecoder(NewClient(context.Background(), server.URL), json.Converter{})
+
+			// when
+			for i := 0; i < 3; i++ {
+				_, err := decoder.Decode(wireFormat(test.id, []int64{0}, nil))
+				require.Error(t, err)
+			}
+
+			// then
+			assert.Equal(t, test.requests, requests(test.path))
+		})
+	}
+}
+
+func TestDecoder_DecodeConcurrently(t *testing.T) {
+	// given
+	server, requests := newRegistry(t)
+	decoder := NewDecoder(NewClient(context.Background(), server.URL), json.Converter{})
+
+	// when
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := decoder.Decode(wireFormat(7, []int64{0}, nil))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// then
+	assert.Equal(t, 1, requests("/schemas/ids/7"))
+}
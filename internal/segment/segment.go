@@ -0,0 +1,290 @@
+package segment
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	snappy "github.com/eapache/go-xerial-snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// batchHeaderSize is the size of the record batch header, from the base offset up to the record count included.
+const batchHeaderSize = 61
+
+// the attributes of record batches
+const (
+	compressionMask = 0x07
+	controlFlag     = 0x20
+	logAppendTime   = 0x08
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Reader reads the records of a Kafka log segment file, i.e. record batches of the v2 format (magic 2) one after another.
+// Compressed batches are decompressed, and control records, e.g. transaction markers, are skipped.
+type Reader struct {
+	r         *bufio.Reader
+	topic     string
+	partition int32
+
+	records []*sarama.ConsumerMessage
+	done    bool
+
+	// zstd is created on the first zstd batch, and reused for the next ones
+	zstd *zstd.Decoder
+}
+
+// NewReader returns a reader of a log segment. The records are read as messages of the given topic and partition.
+func NewReader(r io.Reader, topic string, partition int32) *Reader {
+	return &Reader{
+		r:         bufio.NewReader(r),
+		topic:     topic,
+		partition: partition,
+	}
+}
+
+// TopicPartition returns the topic and the partition of a log segment from the name of its directory,
+// e.g. my-topic-3 for /var/lib/kafka/data/my-topic-3/00000000000000000000.log.
+func TopicPartition(path string) (string, int32, bool) {
+	dir := filepath.Base(filepath.Dir(path))
+
+	i := strings.LastIndex(dir, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	partition, err := strconv.ParseInt(dir[i+1:], 10, 32)
+	if err != nil {
+		return "", 0, false
+	}
+	return dir[:i], int32(partition), true
+}
+
+// Next returns the next record of the segment, or io.EOF once all of them are read.
+// An error about a single batch, e.g. a CRC mismatch, is returned once, and reading goes on with the next batch.
+// A truncated segment returns io.ErrUnexpectedEOF, then io.EOF.
+func (r *Reader) Next() (*sarama.ConsumerMessage, error) {
+	for len(r.records) == 0 {
+		if r.done {
+			return nil, io.EOF
+		}
+
+		records, err := r.readBatch()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				r.done = true
+			}
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		r.records = records
+	}
+
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+// readBatch reads the next batch and returns its records.
+func (r *Reader) readBatch() ([]*sarama.ConsumerMessage, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("truncated batch header: %w", io.ErrUnexpectedEOF)
+	}
+
+	baseOffset := int64(binary.BigEndian.Uint64(header))
+	length := int32(binary.BigEndian.Uint32(header[8:]))
+	if length == 0 {
+		// the zeros of a preallocated segment, past its last batch
+		return nil, io.EOF
+	}
+	if length < batchHeaderSize-12 {
+		return nil, fmt.Errorf("invalid length %d of the batch at offset %d: %w", length, baseOffset, io.ErrUnexpectedEOF)
+	}
+
+	batch := make([]byte, length)
+	if _, err := io.ReadFull(r.r, batch); err != nil {
+		return nil, fmt.Errorf("truncated batch at offset %d: %w", baseOffset, io.ErrUnexpectedEOF)
+	}
+
+	return r.parseBatch(baseOffset, batch)
+}
+
+// parseBatch parses a batch, past its base offset and length.
+func (r *Reader) parseBatch(baseOffset int64, batch []byte) ([]*sarama.ConsumerMessage, error) {
+	if magic := int8(batch[4]); magic != 2 {
+		return nil, fmt.Errorf("unsupported magic %d of the batch at offset %d, only record batches v2 are supported", magic, baseOffset)
+	}
+
+	crc := binary.BigEndian.Uint32(batch[5:])
+	if actual := crc32.Checksum(batch[9:], crcTable); actual != crc {
+		return nil, fmt.Errorf("corrupt batch at offset %d: CRC is %08x instead of %08x", baseOffset, actual, crc)
+	}
+
+	attributes := int16(binary.BigEndian.Uint16(batch[9:]))
+	if attributes&controlFlag != 0 {
+		return nil, nil
+	}
+
+	firstTimestamp := int64(binary.BigEndian.Uint64(batch[15:]))
+	maxTimestamp := int64(binary.BigEndian.Uint64(batch[23:]))
+	count := int32(binary.BigEndian.Uint32(batch[45:]))
+
+	records, err := r.decompress(attributes&compressionMask, batch[49:])
+	if err != nil {
+		return nil, fmt.Errorf("decompressing the batch at offset %d: %w", baseOffset, err)
+	}
+
+	d := &decoder{b: records}
+	var res []*sarama.ConsumerMessage
+	for i := int32(0); i < count; i++ {
+		rec, err := d.record()
+		if err != nil {
+			return nil, fmt.Errorf("record %d of the batch at offset %d: %w", i, baseOffset, err)
+		}
+
+		timestamp := time.UnixMilli(firstTimestamp + rec.timestampDelta)
+		if attributes&logAppendTime != 0 {
+			timestamp = time.UnixMilli(maxTimestamp)
+		}
+
+		res = append(res, &sarama.ConsumerMessage{
+			Headers:        rec.headers,
+			Timestamp:      timestamp,
+			BlockTimestamp: time.UnixMilli(maxTimestamp),
+			Key:            rec.key,
+			Value:          rec.value,
+			Topic:          r.topic,
+			Partition:      r.partition,
+			Offset:         baseOffset + rec.offsetDelta,
+		})
+	}
+
+	return res, nil
+}
+
+func (r *Reader) decompress(codec int16, records []byte) ([]byte, error) {
+	switch sarama.CompressionCodec(codec) {
+	case sarama.CompressionNone:
+		return records, nil
+	case sarama.CompressionGZIP:
+		gz, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(gz)
+	case sarama.CompressionSnappy:
+		return snappy.Decode(records)
+	case sarama.CompressionLZ4:
+		return io.ReadAll(lz4.NewReader(bytes.NewReader(records)))
+	case sarama.CompressionZSTD:
+		if r.zstd == nil {
+			d, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			r.zstd = d
+		}
+		return r.zstd.DecodeAll(records, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+}
+
+// decoder decodes the records of a batch, whose lengths and deltas are zigzag varints.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// record is a record of a batch, whose offset and timestamp are deltas from the ones of the batch.
+type record struct {
+	timestampDelta, offsetDelta int64
+	key, value                  []byte
+	headers                     []*sarama.RecordHeader
+}
+
+// record decodes the next record.
+func (d *decoder) record() (record, error) {
+	length := d.varint()
+	if d.err == nil && (length < 0 || length > int64(len(d.b))) {
+		return record{}, io.ErrUnexpectedEOF
+	}
+
+	// the record is decoded within its length, so that it's never decoded past its end
+	rd := &decoder{b: d.bytes(int(length))}
+	if d.err != nil {
+		return record{}, d.err
+	}
+
+	_ = rd.bytes(1) // attributes, unused
+	rec := record{
+		timestampDelta: rd.varint(),
+		offsetDelta:    rd.varint(),
+		key:            rd.nullableBytes(),
+		value:          rd.nullableBytes(),
+	}
+	for i := rd.varint(); i > 0 && rd.err == nil; i-- {
+		rec.headers = append(rec.headers, &sarama.RecordHeader{Key: rd.nullableBytes(), Value: rd.nullableBytes()})
+	}
+
+	return rec, rd.err
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+// nullableBytes decodes bytes prefixed with their length, where a length of -1 is null.
+func (d *decoder) nullableBytes() []byte {
+	n := d.varint()
+	if d.err != nil || n < 0 {
+		return nil
+	}
+	if n > int64(len(d.b)) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return d.bytes(int(n))
+}
@@ -0,0 +1,277 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_Next(t *testing.T) {
+	firstTimestamp := time.UnixMilli(1646218065015)
+
+	newBatch := func(firstOffset int64, codec sarama.CompressionCodec) *sarama.RecordBatch {
+		return &sarama.RecordBatch{
+			FirstOffset:     firstOffset,
+			Version:         2,
+			Codec:           codec,
+			LastOffsetDelta: 1,
+			FirstTimestamp:  firstTimestamp,
+			MaxTimestamp:    firstTimestamp.Add(time.Second),
+			ProducerID:      -1,
+			ProducerEpoch:   -1,
+			FirstSequence:   -1,
+			Records: []*sarama.Record{
+				{
+					Key:     []byte("key-1"),
+					Value:   []byte("value-1"),
+					Headers: []*sarama.RecordHeader{{Key: []byte("ce-type"), Value: []byte("order.created")}},
+				},
+				{
+					TimestampDelta: time.Second,
+					OffsetDelta:    1,
+					Value:          []byte("value-2"),
+				},
+			},
+		}
+	}
+	newMessages := func(firstOffset int64, timestamps ...time.Time) []*sarama.ConsumerMessage {
+		return []*sarama.ConsumerMessage{
+			{
+				Headers:        []*sarama.RecordHeader{{Key: []byte("ce-type"), Value: []byte("order.created")}},
+				Timestamp:      timestamps[0],
+				BlockTimestamp: firstTimestamp.Add(time.Second),
+				Key:            []byte("key-1"),
+				Value:          []byte("value-1"),
+				Topic:          "orders",
+				Partition:      3,
+				Offset:         firstOffset,
+			},
+			{
+				Timestamp:      timestamps[1],
+				BlockTimestamp: firstTimestamp.Add(time.Second),
+				Value:          []byte("value-2"),
+				Topic:          "orders",
+				Partition:      3,
+				Offset:         firstOffset + 1,
+			},
+		}
+	}
+
+	logAppendTime := newBatch(0, sarama.CompressionNone)
+	logAppendTime.LogAppendTime = true
+
+	control := &sarama.RecordBatch{
+		FirstOffset:    2,
+		Version:        2,
+		Control:        true,
+		FirstTimestamp: firstTimestamp,
+		MaxTimestamp:   firstTimestamp,
+		// a commit marker: version 0 and type 1 as key, version 0 and coordinator epoch 0 as value
+		Records: []*sarama.Record{{Key: []byte{0, 0, 0, 1}, Value: []byte{0, 0, 0, 0, 0, 0}}},
+	}
+
+	corrupt := encodeBatch(t, newBatch(2, sarama.CompressionNone))
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	magic1 := encodeBatch(t, newBatch(2, sarama.CompressionNone))
+	magic1[16] = 1
+
+	tests := []struct {
+		name     string
+		segment  []byte
+		expected []*sarama.ConsumerMessage
+		errs     []string
+	}{
+		{
+			name:     "uncompressed",
+			segment:  encodeBatch(t, newBatch(0, sarama.CompressionNone)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "gzip",
+			segment:  encodeBatch(t, newBatch(0, sarama.CompressionGZIP)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "snappy",
+			segment:  encodeBatch(t, newBatch(0, sarama.CompressionSnappy)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "lz4",
+			segment:  encodeBatch(t, newBatch(0, sarama.CompressionLZ4)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "zstd",
+			segment:  encodeBatch(t, newBatch(0, sarama.CompressionZSTD)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "log append time",
+			segment:  encodeBatch(t, logAppendTime),
+			expected: newMessages(0, firstTimestamp.Add(time.Second), firstTimestamp.Add(time.Second)),
+		},
+		{
+			name: "batches with control records skipped",
+			segment: appendSlices(
+				encodeBatch(t, newBatch(0, sarama.CompressionNone)),
+				encodeBatch(t, control),
+				encodeBatch(t, newBatch(3, sarama.CompressionZSTD)),
+			),
+			expected: append(
+				newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+				newMessages(3, firstTimestamp, firstTimestamp.Add(time.Second))...,
+			),
+		},
+		{
+			name:     "zeros of a preallocated segment",
+			segment:  appendSlices(encodeBatch(t, newBatch(0, sarama.CompressionNone)), make([]byte, 100)),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+		},
+		{
+			name:     "corrupt batch skipped",
+			segment:  appendSlices(corrupt, encodeBatch(t, newBatch(4, sarama.CompressionNone))),
+			expected: newMessages(4, firstTimestamp, firstTimestamp.Add(time.Second)),
+			errs:     []string{"corrupt batch at offset 2: CRC is "},
+		},
+		{
+			name:     "unsupported magic",
+			segment:  magic1,
+			expected: nil,
+			errs:     []string{"unsupported magic 1 of the batch at offset 2, only record batches v2 are supported"},
+		},
+		{
+			name: "truncated",
+			segment: appendSlices(
+				encodeBatch(t, newBatch(0, sarama.CompressionNone)),
+				encodeBatch(t, newBatch(2, sarama.CompressionNone))[:70],
+			),
+			expected: newMessages(0, firstTimestamp, firstTimestamp.Add(time.Second)),
+			errs:     []string{"truncated batch at offset 2: unexpected EOF"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			r := NewReader(bytes.NewReader(test.segment), "orders", 3)
+
+			// when
+			var messages []*sarama.ConsumerMessage
+			var errs []string
+			for {
+				m, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					errs = append(errs, err.Error())
+					continue
+				}
+				messages = append(messages, m)
+			}
+
+			// then
+			assert.Equal(t, test.expected, messages)
+			require.Len(t, errs, len(test.errs))
+			for i, err := range test.errs {
+				assert.Contains(t, errs[i], err)
+			}
+		})
+	}
+}
+
+func TestTopicPartition(t *testing.T) {
+	tests := []struct {
+		path      string
+		topic     string
+		partition int32
+		ok        bool
+	}{
+		{path: "/var/lib/kafka/data/my-topic-3/00000000000000000000.log", topic: "my-topic", partition: 3, ok: true},
+		{path: "orders-12/00000000000000001500.log", topic: "orders", partition: 12, ok: true},
+		{path: "/tmp/00000000000000000000.log"},
+		{path: "00000000000000000000.log"},
+		{path: "/tmp/orders-x/00000000000000000000.log"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			topic, partition, ok := TopicPartition(test.path)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.topic, topic)
+			assert.Equal(t, test.partition, partition)
+		})
+	}
+}
+
+// encodeBatch encodes a record batch the same way it's stored in log segments, with sarama's encoders.
+// Sarama doesn't export them, so the batch is taken from a produce request sent to a fake broker.
+func encodeBatch(t *testing.T, batch *sarama.RecordBatch) []byte {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = ln.Close() }()
+
+	requests := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(requests)
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			close(requests)
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			close(requests)
+			return
+		}
+		requests <- request
+	}()
+
+	broker := sarama.NewBroker(ln.Addr().String())
+	require.NoError(t, broker.Open(sarama.NewConfig()))
+	defer func() { _ = broker.Close() }()
+
+	produce := &sarama.ProduceRequest{Version: 3, RequiredAcks: sarama.NoResponse, Timeout: 1000}
+	produce.AddBatch("orders", 3, batch)
+	_, err = broker.Produce(produce)
+	require.NoError(t, err)
+
+	request, ok := <-requests
+	require.True(t, ok, "the fake broker didn't receive the produce request")
+
+	// api key, api version, correlation id
+	request = request[8:]
+	// client id
+	request = request[2+binary.BigEndian.Uint16(request):]
+	// null transactional id, acks, timeout, topic count
+	request = request[2+2+4+4:]
+	// topic
+	request = request[2+binary.BigEndian.Uint16(request):]
+	// partition count, partition
+	request = request[4+4:]
+	// the records, prefixed with their size
+	return request[4 : 4+binary.BigEndian.Uint32(request)]
+}
+
+func appendSlices(ss ...[]byte) []byte {
+	var res []byte
+	for _, s := range ss {
+		res = append(res, s...)
+	}
+	return res
+}